@@ -0,0 +1,133 @@
+//go:build linux
+
+package modbusorm
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// asciiLRC the LRC of the ASCII frame, the two's complement of the sum of the bytes
+func asciiLRC(frame []byte) byte {
+	var sum byte
+	for _, b := range frame {
+		sum += b
+	}
+	return -sum
+}
+
+// openPTY open a pseudo terminal, return the master and the path of the slave, like a serial port
+func openPTY(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo terminal not available: %v", err)
+	}
+	raw, err := master.SyscallConn()
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+	var n uint32
+	var ioctlErr syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		var unlock int32
+		if _, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); ioctlErr != 0 {
+			return
+		}
+		_, _, ioctlErr = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	})
+	if err != nil || ioctlErr != 0 {
+		master.Close()
+		t.Skipf("pseudo terminal not available: %v %v", err, ioctlErr)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+// serveASCII serve the device with ASCII frames on a pseudo terminal, return the path of the serial port
+/*
+	The responses are written in two parts, so the client must read up to the CR LF.
+*/
+func serveASCII(t *testing.T, d *testDevice) string {
+	master, name := openPTY(t)
+	t.Cleanup(func() { master.Close() })
+	go func() {
+		r := bufio.NewReader(master)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			i := strings.IndexByte(line, ':')
+			if i < 0 {
+				continue
+			}
+			frame, err := hex.DecodeString(line[i+1:])
+			if err != nil || len(frame) < 3 || asciiLRC(frame[:len(frame)-1]) != frame[len(frame)-1] {
+				continue
+			}
+			resp := append([]byte{frame[0]}, d.handle(frame[1:len(frame)-1])...)
+			resp = append(resp, asciiLRC(resp))
+			out := ":" + strings.ToUpper(hex.EncodeToString(resp)) + "\r\n"
+			half := len(out) / 2
+			if _, err := master.WriteString(out[:half]); err != nil {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			if _, err := master.WriteString(out[half:]); err != nil {
+				return
+			}
+		}
+	}()
+	return name
+}
+
+func TestModbusASCII(t *testing.T) {
+	port := serveASCII(t, newTestDevice(20))
+	points := Point{
+		"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1},
+		"energy":  {Addr: 11, Quantity: 2, DataType: PointDataTypeU32},
+		"fault":   {Addr: 20, Quantity: 1},
+	}
+	m := NewModbusASCII(port, points, WithTimeout(time.Second))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.SetValue(ctx, "voltage", uint16(2305)); err != nil {
+		t.Fatalf("SetValue() error = %v", err)
+	}
+	if err := m.SetValue(ctx, "energy", uint32(70000)); err != nil {
+		t.Fatalf("SetValue() of 2 registers error = %v", err)
+	}
+	var data struct {
+		Voltage float64 `morm:"voltage"`
+		Energy  uint32  `morm:"energy"`
+	}
+	if err := m.GetValues(ctx, &data); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if data.Voltage != 230.5 || data.Energy != 70000 {
+		t.Fatalf("GetValues() = %+v, want voltage 230.5 and energy 70000", data)
+	}
+
+	var fault int
+	if code, ok := ExceptionCode(m.GetValue(ctx, "fault", &fault)); !ok || code != 2 {
+		t.Fatalf("GetValue() of an illegal address exception = %d, %v, want 2", code, ok)
+	}
+	// The exception is a whole frame, the bus is still in sync
+	var voltage float64
+	if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 230.5 {
+		t.Fatalf("GetValue() after the exception = %v, %v", voltage, err)
+	}
+}
@@ -0,0 +1,234 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerState state of the circuit breaker
+type BreakerState uint8
+
+const (
+	// BreakerClosed requests are sent
+	BreakerClosed BreakerState = iota
+	// BreakerOpen requests fail fast with ErrDeviceUnavailable
+	BreakerOpen
+	// BreakerHalfOpen trial requests are sent to probe the device
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig config of the circuit breaker of a device
+/*
+	The breaker opens after Failures consecutive transport failures, like timeouts and refused connections,
+	exception responses don't count as the device is answering,
+	and the errors of the caller, like a canceled context, are ignored.
+	While open, requests fail fast with ErrDeviceUnavailable.
+	After OpenTimeout, up to HalfOpenRequests trial requests are sent,
+	the breaker closes if they succeed, or opens again on a failure.
+*/
+type BreakerConfig struct {
+	// Failures is the number of consecutive transport failures to open the breaker, default 5
+	Failures int
+	// OpenTimeout is the time the breaker stays open before the trial requests, default 30s
+	OpenTimeout time.Duration
+	// HalfOpenRequests is the number of trial requests allowed while half-open, default 1
+	HalfOpenRequests int
+	// OnStateChange is called on each state transition, with the target of the device
+	OnStateChange func(target string, from, to BreakerState)
+}
+
+// UnavailableError the error of a request rejected by an open circuit breaker
+type UnavailableError struct {
+	Target string
+	// Until is the time the trial requests will be allowed
+	Until time.Time
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%v: %s, retry after %s", ErrDeviceUnavailable, e.Target, e.Until.Format(time.RFC3339))
+}
+
+// Is make errors.Is(err, ErrDeviceUnavailable) true
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrDeviceUnavailable
+}
+
+type breaker struct {
+	mutex    sync.Mutex
+	config   BreakerConfig
+	target   string
+	log      Logger
+	state    BreakerState
+	failures int       // consecutive failures while closed
+	openedAt time.Time // the time the breaker opened
+	trials   int       // trial requests sent while half-open
+}
+
+func newBreaker(config BreakerConfig, target string, log Logger) *breaker {
+	if config.Failures <= 0 {
+		config.Failures = 5
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &breaker{config: config, target: target, log: log}
+}
+
+// check fail fast if the breaker is open, without taking a trial
+func (b *breaker) check() error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) < b.config.OpenTimeout {
+		return b.unavailable()
+	}
+	return nil
+}
+
+// allow check if a request can be sent, done must be called with its result
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	from := b.state
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.config.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.trials = 0
+	}
+	var err error
+	switch b.state {
+	case BreakerOpen:
+		err = b.unavailable()
+	case BreakerHalfOpen:
+		if b.trials < b.config.HalfOpenRequests {
+			b.trials++
+		} else {
+			err = b.unavailable()
+		}
+	}
+	to := b.state
+	b.mutex.Unlock()
+
+	b.notify(from, to)
+	return err
+}
+
+// done record the result of a request allowed by allow
+/*
+	The errors of the caller, like a canceled context, say nothing about the device,
+	the trial taken by allow is given back and the state is kept.
+*/
+func (b *breaker) done(err error) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	from := b.state
+	switch {
+	case isCallerError(err):
+		if b.state == BreakerHalfOpen && b.trials > 0 {
+			b.trials--
+		}
+	case isDeviceFailure(err):
+		b.fail()
+	default:
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.state = BreakerClosed
+		}
+	}
+	to := b.state
+	b.mutex.Unlock()
+
+	b.notify(from, to)
+}
+
+// failed record the failure to get a connection outside a request, only the device failures count
+func (b *breaker) failed(err error) {
+	if b == nil || !isDeviceFailure(err) {
+		return
+	}
+	b.mutex.Lock()
+	from := b.state
+	b.fail()
+	to := b.state
+	b.mutex.Unlock()
+
+	b.notify(from, to)
+}
+
+// fail count a device failure, open the breaker if needed, the caller must hold the mutex
+func (b *breaker) fail() {
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.config.Failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// unavailable the error while open, the caller must hold the mutex
+func (b *breaker) unavailable() error {
+	return &UnavailableError{Target: b.target, Until: b.openedAt.Add(b.config.OpenTimeout)}
+}
+
+func (b *breaker) notify(from, to BreakerState) {
+	if from == to {
+		return
+	}
+	b.log.Warn("modbus circuit breaker state changed", "from", from.String(), "to", to.String())
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(b.target, from, to)
+	}
+}
+
+func (b *breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state
+}
+
+// isDeviceFailure check if err means the device can't be reached
+/*
+	Exception responses mean the device is answering,
+	and the errors of the caller say nothing about the device.
+*/
+func isDeviceFailure(err error) bool {
+	return err != nil && isTransportError(err) && !isCallerError(err)
+}
+
+// isCallerError check if err comes from the caller rather than the device, like a canceled context or a closed pool
+func isCallerError(err error) bool {
+	return errors.Is(err, ErrDeviceUnavailable) || errors.Is(err, ErrPoolClosed) || errors.Is(err, ErrRequestRejected) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// BreakerState Get the state of the circuit breaker, closed if it is not enabled
+func (m *Modbus) BreakerState() BreakerState {
+	return m.breaker.State()
+}
@@ -0,0 +1,82 @@
+package modbusorm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// halfOpenBreaker a breaker opened long enough ago to let the trial requests through
+func halfOpenBreaker(t *testing.T, config BreakerConfig) *breaker {
+	b := newBreaker(config, "test", nopLogger{})
+	b.state = BreakerOpen
+	b.openedAt = time.Now().Add(-b.config.OpenTimeout)
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow() of the trial error = %v", err)
+	}
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("State() = %v, want half-open", b.State())
+	}
+	return b
+}
+
+func TestBreakerHalfOpenTrial(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want BreakerState
+		// wantTrial reports whether another trial is allowed after the result
+		wantTrial bool
+	}{
+		{"success closes", nil, BreakerClosed, true},
+		{"exception closes", &modbus.ModbusError{ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}, BreakerClosed, true},
+		{"transport failure opens", io.EOF, BreakerOpen, false},
+		{"canceled context gives back the trial", fmt.Errorf("conn slave failed: %w", context.Canceled), BreakerHalfOpen, true},
+		{"deadline gives back the trial", context.DeadlineExceeded, BreakerHalfOpen, true},
+		{"closed pool gives back the trial", fmt.Errorf("conn slave failed: %w", ErrPoolClosed), BreakerHalfOpen, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := halfOpenBreaker(t, BreakerConfig{OpenTimeout: time.Minute})
+			b.done(tt.err)
+			if got := b.State(); got != tt.want {
+				t.Fatalf("State() = %v, want %v", got, tt.want)
+			}
+			if err := b.allow(); (err == nil) != tt.wantTrial {
+				t.Fatalf("allow() after the trial error = %v, want trial %v", err, tt.wantTrial)
+			}
+		})
+	}
+}
+
+func TestBreakerCallerErrorsKeepFailures(t *testing.T) {
+	b := newBreaker(BreakerConfig{Failures: 3}, "test", nopLogger{})
+	for _, err := range []error{io.EOF, io.EOF, context.Canceled, io.EOF} {
+		if e := b.allow(); e != nil {
+			t.Fatalf("allow() error = %v", e)
+		}
+		b.done(err)
+	}
+	// The canceled request neither counted nor reset the consecutive failures
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("State() = %v, want open", got)
+	}
+}
+
+func TestBreakerFailed(t *testing.T) {
+	b := halfOpenBreaker(t, BreakerConfig{OpenTimeout: time.Minute})
+
+	// Getting a connection outside a request takes no trial, and the caller errors don't count
+	b.failed(fmt.Errorf("conn slave failed: %w", context.Canceled))
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("State() after a canceled context = %v, want half-open", got)
+	}
+	b.failed(io.EOF)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("State() after a refused connection = %v, want open", got)
+	}
+}
@@ -0,0 +1,427 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// cachedRegisters the registers read per connection target, shared by the modbus of the same device in the process
+var cachedRegisters = struct {
+	sync.Mutex
+	targets map[string]*registerCache
+}{targets: map[string]*registerCache{}}
+
+// registerCache the registers of a device, keyed by register space and address
+/*
+	Each invalidation starts a new generation. The reads are stamped with the generation they started in,
+	so the registers read before a write completed are not stored after the write invalidated them.
+*/
+type registerCache struct {
+	mutex    sync.Mutex
+	regs     map[cacheAddr]cachedRegister
+	inflight map[flightKey]*flightCall
+
+	generation  uint64
+	invalidated map[cacheAddr]uint64 // the generation each register was last invalidated in
+	cleared     uint64               // the generation all the registers were last invalidated in
+}
+
+// cacheAddr the register space, the function code reading it, and the address
+type cacheAddr struct {
+	space byte
+	addr  uint16
+}
+
+type cachedRegister struct {
+	data   [2]byte
+	readAt time.Time
+}
+
+// flightKey a block being read
+type flightKey struct {
+	space      byte
+	start, end uint16
+}
+
+// flightCall the read of a block, the concurrent reads of the same block wait for it
+type flightCall struct {
+	done   chan struct{}
+	blocks []*block
+	err    error
+}
+
+// registersOf get the register cache of the target
+func registersOf(target string) *registerCache {
+	cachedRegisters.Lock()
+	defer cachedRegisters.Unlock()
+
+	c, ok := cachedRegisters.targets[target]
+	if !ok {
+		c = &registerCache{
+			regs:        make(map[cacheAddr]cachedRegister),
+			inflight:    make(map[flightKey]*flightCall),
+			invalidated: make(map[cacheAddr]uint64),
+		}
+		cachedRegisters.targets[target] = c
+	}
+	return c
+}
+
+// lookupRegisters get the register cache of the target if any
+func lookupRegisters(target string) (*registerCache, bool) {
+	cachedRegisters.Lock()
+	defer cachedRegisters.Unlock()
+
+	c, ok := cachedRegisters.targets[target]
+	return c, ok
+}
+
+// get copy the registers of r to regs if all of them were read within ttl
+func (c *registerCache) get(space byte, r AddrRange, ttl time.Duration, now time.Time, regs map[uint16]cachedRegister) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for addr := uint32(r.Start); addr <= uint32(r.End); addr++ {
+		reg, ok := c.regs[cacheAddr{space: space, addr: uint16(addr)}]
+		if !ok || now.Sub(reg.readAt) >= ttl {
+			return false
+		}
+	}
+	for addr := uint32(r.Start); addr <= uint32(r.End); addr++ {
+		regs[uint16(addr)] = c.regs[cacheAddr{space: space, addr: uint16(addr)}]
+	}
+	return true
+}
+
+// put store the registers read from start by a read started in the generation
+/*
+	The registers invalidated since the read started are not stored.
+*/
+func (c *registerCache) put(space byte, start uint16, data []byte, readAt time.Time, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cleared > generation {
+		return
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		addr := cacheAddr{space: space, addr: start + uint16(i/2)}
+		if c.invalidated[addr] > generation {
+			continue
+		}
+		var reg cachedRegister
+		copy(reg.data[:], data[i:i+2])
+		reg.readAt = readAt
+		c.regs[addr] = reg
+	}
+}
+
+// currentGeneration get the generation the reads starting now are stamped with
+func (c *registerCache) currentGeneration() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.generation
+}
+
+// invalidate drop the registers from start
+func (c *registerCache) invalidate(space byte, start, quantity uint16) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for i := uint32(0); i < uint32(quantity); i++ {
+		addr := cacheAddr{space: space, addr: uint16(uint32(start) + i)}
+		delete(c.regs, addr)
+		c.invalidated[addr] = c.generation
+	}
+}
+
+// clear drop all the registers
+func (c *registerCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	c.cleared = c.generation
+	c.regs = make(map[cacheAddr]cachedRegister)
+	c.invalidated = make(map[cacheAddr]uint64)
+}
+
+// join join the read of the block in flight, or start it if the caller is the first, then the caller must call finish
+func (c *registerCache) join(key flightKey) (*flightCall, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if call, ok := c.inflight[key]; ok {
+		return call, false
+	}
+	call := &flightCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	return call, true
+}
+
+// finish end the read of the block, and wake up the callers waiting for it
+func (c *registerCache) finish(key flightKey, call *flightCall, bs []*block, err error) {
+	c.mutex.Lock()
+	delete(c.inflight, key)
+	c.mutex.Unlock()
+
+	call.blocks, call.err = bs, err
+	close(call.done)
+}
+
+// wait wait for the read of the block
+func (call *flightCall) wait(ctx context.Context) ([]*block, error) {
+	select {
+	case <-call.done:
+		return call.blocks, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidateCache drop the registers written from the cache of the target
+func invalidateCache(target string, space byte, start, quantity uint16) {
+	if c, ok := lookupRegisters(target); ok {
+		c.invalidate(space, start, quantity)
+	}
+}
+
+// InvalidateCache drop the cached registers of the device
+func (m *Modbus) InvalidateCache() {
+	if c, ok := lookupRegisters(m.target()); ok {
+		c.clear()
+	}
+}
+
+// pointTTL the time the registers of the point are cached, 0 if not cached
+func (m *Modbus) pointTTL(p PointDetails) time.Duration {
+	if m.cacheTTL <= 0 || p.CacheTTL < 0 {
+		return 0
+	}
+	if p.CacheTTL > 0 {
+		return p.CacheTTL
+	}
+	return m.cacheTTL
+}
+
+// cacheGeneration get the generation of the cache to stamp a read starting now, see cachePut
+func (m *Modbus) cacheGeneration() uint64 {
+	if m.cacheTTL > 0 {
+		return registersOf(m.target()).currentGeneration()
+	}
+	return 0
+}
+
+// cachePut store the registers read if the cache is enabled, generation is the one the read started in
+func (m *Modbus) cachePut(start uint16, data []byte, readAt time.Time, generation uint64) {
+	if m.cacheTTL > 0 {
+		registersOf(m.target()).put(modbus.FuncCodeReadHoldingRegisters, start, data, readAt, generation)
+	}
+}
+
+// cacheGet get the registers of the point if cached within its TTL
+func (m *Modbus) cacheGet(p PointDetails) ([]byte, bool) {
+	ttl := m.pointTTL(p)
+	if ttl <= 0 {
+		return nil, false
+	}
+	r := p.addrRange()
+	regs := make(map[uint16]cachedRegister, r.End-r.Start+1)
+	if !registersOf(m.target()).get(modbus.FuncCodeReadHoldingRegisters, r, ttl, time.Now(), regs) {
+		return nil, false
+	}
+	data := make([]byte, 0, 2*len(regs))
+	for addr := uint32(r.Start); addr <= uint32(r.End); addr++ {
+		reg := regs[uint16(addr)]
+		data = append(data, reg.data[:]...)
+	}
+	return data, true
+}
+
+// getValuesCached read the points of v expired or missing in the cache, and set v with the registers cached and read
+/*
+	The blocks of the points expired are planned without the plan cache, as the points change with the time.
+	The concurrent reads of the same block are done once.
+*/
+func (m *Modbus) getValuesCached(ctx context.Context, v any, filterMap map[string]bool) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("not support for %T", v)
+	}
+	cache := registersOf(m.target())
+	space := byte(modbus.FuncCodeReadHoldingRegisters)
+	now := time.Now()
+
+	// The registers cached are copied, so the points are set with consistent values
+	regs := make(map[uint16]cachedRegister)
+	var requested, expired []AddrRange
+	m.walkPoints(val.Elem(), filterMap, func(name string, value reflect.Value, s sample) {
+		p := m.points[name]
+		r := p.addrRange()
+		requested = append(requested, r)
+		if ttl := m.pointTTL(p); ttl <= 0 || !cache.get(space, r, ttl, now, regs) {
+			expired = append(expired, r)
+		}
+	})
+	if len(requested) == 0 {
+		return fmt.Errorf("no address found")
+	}
+
+	var failed []*block
+	if len(expired) > 0 {
+		planned := planBlocks(expired, planConfig{
+			maxQuantity: m.maxBlockQuantity(),
+			maxGap:      m.maxGapInBlock,
+			unreadable:  m.unreadableRanges(),
+			cost:        m.currentCostModel(),
+		})
+
+		// Read the blocks no one is reading, and wait for the others
+		mine := make(blocks)
+		leading := make(map[flightKey]*flightCall)
+		waiting := make(map[flightKey]*flightCall)
+		waitingBlocks := make(map[flightKey]*block)
+		for _, b := range planned {
+			key := flightKey{space: space, start: b.start, end: b.end}
+			if call, leader := cache.join(key); leader {
+				mine[b.start] = b
+				leading[key] = call
+			} else {
+				waiting[key] = call
+				waitingBlocks[key] = b
+			}
+		}
+		read, err := m.readLeading(ctx, cache, leading, mine)
+		if err != nil {
+			return err
+		}
+		for key, call := range waiting {
+			bs, err := m.waitFlight(ctx, cache, key, call, waitingBlocks[key])
+			if err != nil {
+				if !m.partialResults {
+					return err
+				}
+				bs = []*block{{start: key.start, end: key.end, err: err}}
+			}
+			read = append(read, bs...)
+		}
+
+		for _, b := range read {
+			if b.err != nil {
+				failed = append(failed, b)
+				continue
+			}
+			for i := 0; i+1 < len(b.vaulues); i += 2 {
+				var reg cachedRegister
+				copy(reg.data[:], b.vaulues[i:i+2])
+				reg.readAt = b.readAt
+				regs[b.start+uint16(i/2)] = reg
+			}
+		}
+	}
+
+	return m.setAddressValues(ctx, v, assembleBlocks(requested, regs, failed), filterMap)
+}
+
+// readLeading read the blocks the caller leads, and wake up the callers waiting for them
+func (m *Modbus) readLeading(ctx context.Context, cache *registerCache, leading map[flightKey]*flightCall, mine blocks) (read []*block, err error) {
+	defer func() {
+		for key, call := range leading {
+			cache.finish(key, call, read, err)
+		}
+	}()
+	if len(mine) == 0 {
+		return nil, nil
+	}
+	err = m.readBlocks(ctx, mine)
+	for _, b := range mine {
+		read = append(read, b)
+	}
+	return read, err
+}
+
+// waitFlight wait for the read of the block by another caller
+/*
+	The context of the leader is not the one of the caller: if the read failed as the leader gave up,
+	the caller reads the block itself, or waits for the new leader.
+*/
+func (m *Modbus) waitFlight(ctx context.Context, cache *registerCache, key flightKey, call *flightCall, b *block) ([]*block, error) {
+	for {
+		bs, err := call.wait(ctx)
+		if ctx.Err() != nil || !call.canceled(key) {
+			return bs, err
+		}
+		var leader bool
+		if call, leader = cache.join(key); leader {
+			return m.readLeading(ctx, cache, map[flightKey]*flightCall{key: call}, blocks{b.start: b})
+		}
+	}
+}
+
+// canceled check if the read of the block failed for the context of the leader
+func (call *flightCall) canceled(key flightKey) bool {
+	if isContextError(call.err) {
+		return true
+	}
+	for _, b := range call.blocks {
+		if (AddrRange{Start: b.start, End: b.end}).overlaps(key.start, key.end) && isContextError(b.err) {
+			return true
+		}
+	}
+	return false
+}
+
+// isContextError check if err is the error of a canceled or expired context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// assembleBlocks build the blocks of the ranges requested from the registers, the ranges not read report the error
+func assembleBlocks(requested []AddrRange, regs map[uint16]cachedRegister, failed []*block) blocks {
+	sort.Slice(requested, func(i, j int) bool { return requested[i].Start < requested[j].Start })
+	var merged []AddrRange
+	for _, r := range requested {
+		if n := len(merged); n > 0 && uint32(r.Start) <= uint32(merged[n-1].End)+1 {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	bs := make(blocks, len(merged))
+	for _, r := range merged {
+		b := &block{start: r.Start, end: r.End, ranges: []AddrRange{r}}
+		data := make([]byte, 0, 2*(int(r.End-r.Start)+1))
+		for addr := uint32(r.Start); addr <= uint32(r.End); addr++ {
+			reg, ok := regs[uint16(addr)]
+			if !ok {
+				b.err = ErrCacheMiss
+				for _, f := range failed {
+					if r.overlaps(f.start, f.end) {
+						b.err = f.err
+						break
+					}
+				}
+				break
+			}
+			data = append(data, reg.data[:]...)
+			if b.readAt.IsZero() || reg.readAt.Before(b.readAt) {
+				b.readAt = reg.readAt
+			}
+		}
+		if b.err == nil {
+			b.vaulues = data
+		}
+		bs[r.Start] = b
+	}
+	return bs
+}
@@ -0,0 +1,113 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestCacheWaiterReadsAfterLeaderCanceled(t *testing.T) {
+	var calls int32
+	started := make(chan struct{})
+	// The first read hangs until its context is canceled
+	hang := func(ctx context.Context, req Request, next Invoker) (Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return Response{}, ctx.Err()
+		}
+		return next(ctx, req)
+	}
+	host, port := serveTCP(t, newTestDevice())
+	points := Point{"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1}}
+	m := NewModbusTCP(host, port, points, WithTimeout(time.Second), WithCache(time.Minute), WithInterceptor(hang))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	type values struct {
+		Voltage float64 `morm:"voltage"`
+	}
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var leaderErr, waiterErr error
+	var waiter values
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var leader values
+		leaderErr = m.GetValuesBlock(leaderCtx, &leader)
+	}()
+	<-started
+	go func() {
+		defer wg.Done()
+		waiterErr = m.GetValuesBlock(context.Background(), &waiter)
+	}()
+	// Let the waiter join the read in flight before the leader gives up
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	if !errors.Is(leaderErr, context.Canceled) {
+		t.Fatalf("GetValuesBlock() of the leader error = %v, want canceled", leaderErr)
+	}
+	if waiterErr != nil || waiter.Voltage != 1 {
+		t.Fatalf("GetValuesBlock() of the waiter = %+v, %v, want 1", waiter, waiterErr)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("interceptor called %d times, want 2", n)
+	}
+}
+
+func TestCacheWriteDuringRead(t *testing.T) {
+	d := newTestDevice()
+	reading, written := make(chan struct{}), make(chan struct{})
+	var slow int32
+	// The first read gets the registers before the write, and answers after it
+	d.setFault(func(pdu []byte) []byte {
+		if pdu[0] != modbus.FuncCodeReadHoldingRegisters || !atomic.CompareAndSwapInt32(&slow, 0, 1) {
+			return nil
+		}
+		resp := appendUint16([]byte{pdu[0], 2}, d.register(10))
+		close(reading)
+		<-written
+		return resp
+	})
+	host, port := serveTCP(t, d)
+	points := Point{"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1}}
+	m := NewModbusTCP(host, port, points, WithTimeout(time.Second), WithCache(time.Minute))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() {
+		var voltage float64
+		done <- m.GetValue(ctx, "voltage", &voltage)
+	}()
+	<-reading
+	if err := m.SetValue(ctx, "voltage", uint16(2305)); err != nil {
+		t.Fatalf("SetValue() error = %v", err)
+	}
+	close(written)
+	if err := <-done; err != nil {
+		t.Fatalf("GetValue() of the slow read error = %v", err)
+	}
+
+	// The registers read before the write are not cached
+	var voltage float64
+	if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 230.5 {
+		t.Fatalf("GetValue() after the write = %v, %v, want 230.5", voltage, err)
+	}
+	if n := d.requestCount(modbus.FuncCodeReadHoldingRegisters); n != 2 {
+		t.Fatalf("reads = %d, want 2", n)
+	}
+}
@@ -0,0 +1,138 @@
+package modbusorm
+
+import (
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// ConnHandler a modbus.ClientHandler opening its own connection, like the handlers of the transports
+type ConnHandler interface {
+	modbus.ClientHandler
+	Connect() error
+	Close() error
+}
+
+// ModbusHandlerClient the Client of a ConnHandler, for the TLS, UDP, RTU over TCP and ASCII transports
+/*
+	Once a request failed with a transport error, the client is not alive, and the TCP pool replaces it.
+*/
+type ModbusHandlerClient struct {
+	Client  modbus.Client
+	Handler ConnHandler
+	// slaveID is the slave id field of the handler
+	slaveID    *byte
+	createTime time.Time
+	broken     bool
+}
+
+// NewModbusHandlerClient allocates a ModbusHandlerClient of the handler, slaveID is the slave id field of the handler
+func NewModbusHandlerClient(handler ConnHandler, slaveID *byte) *ModbusHandlerClient {
+	return &ModbusHandlerClient{
+		Client:     modbus.NewClient(handler),
+		Handler:    handler,
+		slaveID:    slaveID,
+		createTime: time.Now(),
+	}
+}
+
+func (c *ModbusHandlerClient) Connect() error {
+	return c.Handler.Connect()
+}
+
+func (c *ModbusHandlerClient) Close() error {
+	return c.Handler.Close()
+}
+
+// IsAlive report false once a request failed with a transport error
+func (c *ModbusHandlerClient) IsAlive() bool {
+	return !c.broken
+}
+
+// track mark the connection broken if err is a transport error
+func (c *ModbusHandlerClient) track(err error) {
+	if isTransportError(err) {
+		c.broken = true
+	}
+}
+
+func (c *ModbusHandlerClient) CreateTime() time.Time {
+	return c.createTime
+}
+
+func (c *ModbusHandlerClient) SetSlaveID(slaveID uint8) {
+	*c.slaveID = slaveID
+}
+
+func (c *ModbusHandlerClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
+	results, err = c.Client.ReadCoils(address, quantity)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
+	results, err = c.Client.ReadDiscreteInputs(address, quantity)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
+	results, err = c.Client.WriteSingleCoil(address, value)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
+	results, err = c.Client.WriteMultipleCoils(address, quantity, value)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
+	results, err = c.Client.ReadInputRegisters(address, quantity)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
+	results, err = c.Client.ReadHoldingRegisters(address, quantity)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
+	results, err = c.Client.WriteSingleRegister(address, value)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
+	results, err = c.Client.WriteMultipleRegisters(address, quantity, value)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
+	results, err = c.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
+	results, err = c.Client.MaskWriteRegister(address, andMask, orMask)
+	c.track(err)
+	return
+}
+
+func (c *ModbusHandlerClient) ReadFIFOQueue(address uint16) (results []byte, err error) {
+	results, err = c.Client.ReadFIFOQueue(address)
+	c.track(err)
+	return
+}
+
+// Diagnostics send a diagnostics request (FC08)
+func (c *ModbusHandlerClient) Diagnostics(subFunction uint16, data []byte) (results []byte, err error) {
+	results, err = diagnostics(c.Handler, subFunction, data)
+	c.track(err)
+	return
+}
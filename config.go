@@ -1,22 +1,39 @@
 package modbusorm
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 // modbusTCP Connection config of TCP
 type modbusTCP struct {
-	Host            string
-	Port            int
-	MaxOpenConns    int
-	ConnMaxLifetime time.Duration
+	Host                string
+	Port                int
+	MaxOpenConns        int
+	ConnMaxLifetime     time.Duration
+	MaxIdleConns        int
+	ConnMaxIdleTime     time.Duration
+	Lazy                bool
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
+	HealthCheckInterval time.Duration
+	HealthCheckProbe    Probe
+	Retransmits         int // for UDP
+	TLSConfig           *tls.Config
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSCAFile           string
+	HandshakeTimeout    time.Duration
 }
 
 // modbusRTU Connection config of RTU
 type modbusRTU struct {
-	ComAddr  string
-	BaudRate int
-	DataBits int
-	Parity   string // (N, E, O)
-	StopBits int
+	ComAddr    string
+	BaudRate   int
+	DataBits   int
+	Parity     string // (N, E, O)
+	StopBits   int
+	FrameDelay time.Duration
 }
 
 type ModbusOption func(*Modbus)
@@ -49,6 +66,62 @@ func WithConnMaxLifetime(connMaxLifetime time.Duration) ModbusOption {
 	}
 }
 
+// WithMaxIdleConns Set the max idle connections of the modbus TCP, default the max open connections
+func WithMaxIdleConns(maxIdleConns int) ModbusOption {
+	return func(d *Modbus) {
+		d.MaxIdleConns = maxIdleConns
+	}
+}
+
+// WithConnMaxIdleTime Set the max time a connection of the modbus TCP may be idle, default no limit
+/*
+	Many PLCs drop the TCP sessions idle for a while (like 60s),
+	set it a little shorter so that the dropped sessions are never handed out.
+	Stale connections are closed in the background, and on Get a new one is dialed instead.
+*/
+func WithConnMaxIdleTime(connMaxIdleTime time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.ConnMaxIdleTime = connMaxIdleTime
+	}
+}
+
+// WithLazyConnect Set the lazy connect mode of the modbus TCP
+/*
+	In lazy mode, Conn does not dial the device and never fails because the device is offline.
+	The pool starts with zero connections and dials on demand.
+	When a dial fails, the connection is down and reconnected in the background,
+	the requests meanwhile still dial the device, watch the state with State and WatchState.
+*/
+func WithLazyConnect(lazy bool) ModbusOption {
+	return func(d *Modbus) {
+		d.Lazy = lazy
+	}
+}
+
+// WithReconnectBackoff Set the min and max backoff of the background reconnect of the modbus TCP
+func WithReconnectBackoff(minBackoff, maxBackoff time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.ReconnectMinBackoff = minBackoff
+		d.ReconnectMaxBackoff = maxBackoff
+	}
+}
+
+// WithHealthCheck Set the health check of the idle connections of the modbus TCP
+/*
+	A connection is marked bad when a request on it fails with a transport error,
+	and closed when it is put back to the pool.
+	Besides, the connections idle for at least interval are probed with probe:
+	ProbeDiagnosticsEcho() for a diagnostics echo (FC08),
+	ProbeRegister(addr) for reading a known register,
+	or nil to disable the check, which is the default.
+*/
+func WithHealthCheck(interval time.Duration, probe Probe) ModbusOption {
+	return func(d *Modbus) {
+		d.HealthCheckInterval = interval
+		d.HealthCheckProbe = probe
+	}
+}
+
 // WithComAddr Set the com address of the modbus RTU
 func WithComAddr(comAddr string) ModbusOption {
 	return func(d *Modbus) {
@@ -56,6 +129,46 @@ func WithComAddr(comAddr string) ModbusOption {
 	}
 }
 
+// WithTLS Set the TLS config of the modbus TCP, for Modbus/TCP Security (usually port 802)
+/*
+	The config should have the client certificate for the mutual authentication,
+	and the CA certificates to verify the device. TLS 1.2 is the min version.
+*/
+func WithTLS(config *tls.Config) ModbusOption {
+	return func(d *Modbus) {
+		d.TLSConfig = config
+	}
+}
+
+// WithTLSFiles Set the PEM files of the client certificate, key and CA certificates of the modbus TCP
+/*
+	The files are loaded by Conn, the system CA certificates are used if caFile is empty.
+*/
+func WithTLSFiles(certFile, keyFile, caFile string) ModbusOption {
+	return func(d *Modbus) {
+		d.TLSCertFile = certFile
+		d.TLSKeyFile = keyFile
+		d.TLSCAFile = caFile
+	}
+}
+
+// WithHandshakeTimeout Set the max time of the TLS handshake, default the timeout
+func WithHandshakeTimeout(timeout time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.HandshakeTimeout = timeout
+	}
+}
+
+// WithRetransmits Set the number of times a request is sent again on timeout, for modbus UDP, default 2
+/*
+	The timeout is shared by the attempts, each attempt waits timeout / (retransmits + 1).
+*/
+func WithRetransmits(retransmits int) ModbusOption {
+	return func(d *Modbus) {
+		d.Retransmits = retransmits
+	}
+}
+
 // WithBaudRate Set the baud rate of the modbus RTU
 func WithBaudRate(baudRate int) ModbusOption {
 	return func(d *Modbus) {
@@ -84,6 +197,17 @@ func WithStopBits(stopBits int) ModbusOption {
 	}
 }
 
+// WithFrameDelay Set the silent interval between two transactions on the modbus RTU
+/*
+	Default 3.5 character times computed from the baud rate, data bits, parity and stop bits,
+	or 1.75ms for baud rates greater than 19200.
+*/
+func WithFrameDelay(frameDelay time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.FrameDelay = frameDelay
+	}
+}
+
 // WithTimeout Set the timeout of the modbus
 func WithTimeout(timeout time.Duration) ModbusOption {
 	return func(d *Modbus) {
@@ -121,7 +245,8 @@ func WithMaxQuantity(maxQuantity uint16) ModbusOption {
 
 	It's much more efficient to read by block (one request versus three requests).
 
-	With `WithMaxBlockSize` and `WithMaxGapInBlock` you can control the size of block and the request number.
+	With `WithMaxBlockSize` and `WithMaxGapInBlock` you can control the size of block and the request number,
+	and with `WithCostModel` how the planner weighs one more request against reading a gap.
 */
 func WithBlock(block bool) ModbusOption {
 	return func(d *Modbus) {
@@ -142,3 +267,184 @@ func WithMaxGapInBlock(maxGapInBlock uint16) ModbusOption {
 		d.maxGapInBlock = maxGapInBlock
 	}
 }
+
+// WithPartialResults Set the partial results mode of GetValues
+/*
+	If partial is true, GetValues will keep going when a point fails,
+	fill every field it can and return a *ValuesError listing each failed point with its cause.
+	Otherwise, GetValues will abort on the first failure.
+*/
+func WithPartialResults(partial bool) ModbusOption {
+	return func(d *Modbus) {
+		d.partialResults = partial
+	}
+}
+
+// WithStaleAfter Set the time the last value of a Sample stays uncertain after the reads failed, default no limit
+/*
+	Once the last good read is older than staleAfter, the Sample fields of the points failed turn bad.
+*/
+func WithStaleAfter(staleAfter time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.staleAfter = staleAfter
+	}
+}
+
+// WithForbiddenRanges Set the address ranges that must never be read
+/*
+	Some devices crash or return garbage when certain registers are read,
+	for example write-only command registers inside a measurement range.
+	Blocks will be split around these ranges even if WithMaxGapInBlock would merge across,
+	and Conn will fail if a readable point is inside them.
+
+	Ranges can also be declared in the point table with PointDetails.Forbidden.
+*/
+func WithForbiddenRanges(ranges ...AddrRange) ModbusOption {
+	return func(d *Modbus) {
+		d.forbidden = append(d.forbidden, ranges...)
+	}
+}
+
+// WithHoleTTL Set the time an unreadable gap learned from the device is remembered, default forever
+/*
+	Once expired, the blocks are planned across the gap again, and the gap is learned again if still rejected.
+	See Modbus.ResetHoles to forget the gaps at once.
+*/
+func WithHoleTTL(ttl time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.holeTTL = ttl
+	}
+}
+
+// WithCostModel Set the cost model used to plan the blocks
+/*
+	Blocks are planned to minimize the total cost of the reads,
+	a gap is bridged only if reading it costs less than one more request.
+	Default 20ms per request and 200us per register.
+*/
+func WithCostModel(costModel CostModel) ModbusOption {
+	return func(d *Modbus) {
+		d.costModel = costModel
+	}
+}
+
+// WithMeasuredCost Set the cost model to be measured from the observed latencies
+/*
+	The configured cost model is used until enough block reads are observed.
+*/
+func WithMeasuredCost(measure bool) ModbusOption {
+	return func(d *Modbus) {
+		d.measureCost = measure
+	}
+}
+
+// WithReadConcurrency Set the max number of blocks read in parallel
+/*
+	In block mode, blocks are fanned out across up to n pooled connections,
+	for gateways that support concurrent transactions.
+	Only work with Modbus TCP and UDP, and n should not be larger than the max open connections. Default 1.
+*/
+func WithReadConcurrency(n int) ModbusOption {
+	return func(d *Modbus) {
+		if n < 1 {
+			n = 1
+		}
+		d.readConcurrency = n
+	}
+}
+
+// WithRetryPolicy Set the retry policy of the modbus requests, default no retry
+/*
+	Each request, like a block read or a write, is retried on its own.
+	Writes are retried only for the points marked Idempotent.
+*/
+func WithRetryPolicy(policy RetryPolicy) ModbusOption {
+	return func(d *Modbus) {
+		d.retryPolicy = policy
+	}
+}
+
+// WithCircuitBreaker Set the circuit breaker of the device, default disabled
+/*
+	After config.Failures consecutive transport failures, requests fail fast with ErrDeviceUnavailable
+	instead of waiting out the timeout, until a trial request succeeds.
+*/
+func WithCircuitBreaker(config BreakerConfig) ModbusOption {
+	return func(d *Modbus) {
+		d.breakerConfig = &config
+	}
+}
+
+// WithInterceptor Add interceptors wrapping each request sent to the device, the first added is the outermost
+func WithInterceptor(interceptors ...Interceptor) ModbusOption {
+	return func(d *Modbus) {
+		d.interceptors = append(d.interceptors, interceptors...)
+	}
+}
+
+// WithCache Set the default time the registers read are reused, default no cache
+/*
+	The registers are cached per device, shared by the modbus of the same target in the process.
+	GetValues reads only the points expired, the TTL of each point is PointDetails.CacheTTL, default ttl.
+	Writes invalidate the registers written, and concurrent reads of the same block are done once.
+*/
+func WithCache(ttl time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.cacheTTL = ttl
+	}
+}
+
+// WithStrictEnum Set whether the codes not in the enumeration of a point are errors, default false
+/*
+	The unknown codes read are "UNKNOWN(n)" by default, or fail with ErrUnknownEnum in strict mode.
+	In strict mode, the codes not in the enumeration are rejected by the writes too.
+*/
+func WithStrictEnum(strict bool) ModbusOption {
+	return func(d *Modbus) {
+		d.strictEnum = strict
+	}
+}
+
+// WithMetrics Set the metrics of the requests, block plans and pools, default measures nothing
+func WithMetrics(metrics Metrics) ModbusOption {
+	return func(d *Modbus) {
+		if metrics == nil {
+			metrics = nopMetrics{}
+		}
+		d.metrics = metrics
+	}
+}
+
+// WithTracer Set the tracer of the calls, the block planning, the pool waits and the requests, default traces nothing
+func WithTracer(tracer Tracer) ModbusOption {
+	return func(d *Modbus) {
+		if tracer == nil {
+			tracer = nopTracer{}
+		}
+		d.tracer = tracer
+	}
+}
+
+// WithLogger Set the structured logger, like *slog.Logger, default logs nothing
+/*
+	Connection lifecycle, retries and decode errors are logged at info and warn level,
+	requests and block plans at debug level.
+*/
+func WithLogger(logger Logger) ModbusOption {
+	return func(d *Modbus) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		d.logger = logger
+	}
+}
+
+// WithRequestDelay Set the delay after each block read on a connection
+/*
+	To avoid making the server too busy. Default 1ms, 0 means no delay.
+*/
+func WithRequestDelay(delay time.Duration) ModbusOption {
+	return func(d *Modbus) {
+		d.requestDelay = delay
+	}
+}
@@ -0,0 +1,233 @@
+package modbusorm
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// testDevice a register bank answering the PDUs of the transport tests
+/*
+	The registers hold their own address until written.
+	The addresses in illegal are rejected with an illegal data address exception.
+*/
+type testDevice struct {
+	mutex   sync.Mutex
+	regs    map[uint16]uint16
+	illegal map[uint16]bool
+	// fault answers the request PDU instead of the registers if it returns a response, like a failing device
+	fault    func(pdu []byte) []byte
+	requests []byte // the function codes of the requests handled
+	units    []byte // the unit ids of the MBAP requests, the slave ids
+	conns    int    // the connections accepted
+}
+
+func newTestDevice(illegal ...uint16) *testDevice {
+	d := &testDevice{regs: make(map[uint16]uint16), illegal: make(map[uint16]bool)}
+	for _, addr := range illegal {
+		d.illegal[addr] = true
+	}
+	return d
+}
+
+func (d *testDevice) register(addr uint16) uint16 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if v, ok := d.regs[addr]; ok {
+		return v
+	}
+	return addr
+}
+
+func (d *testDevice) setRegister(addr, value uint16) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.regs[addr] = value
+}
+
+func (d *testDevice) setIllegal(addr uint16, illegal bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.illegal[addr] = illegal
+}
+
+func (d *testDevice) isIllegal(addr uint16) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.illegal[addr]
+}
+
+func (d *testDevice) setFault(fault func(pdu []byte) []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.fault = fault
+}
+
+// requestCount the number of the requests with the function code
+func (d *testDevice) requestCount(function byte) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	n := 0
+	for _, f := range d.requests {
+		if f == function {
+			n++
+		}
+	}
+	return n
+}
+
+// unitIDs the unit ids of the MBAP requests
+func (d *testDevice) unitIDs() []byte {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]byte(nil), d.units...)
+}
+
+func (d *testDevice) connCount() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.conns
+}
+
+// handle answer the request PDU with the response PDU
+func (d *testDevice) handle(pdu []byte) []byte {
+	function := pdu[0]
+	d.mutex.Lock()
+	d.requests = append(d.requests, function)
+	fault := d.fault
+	d.mutex.Unlock()
+	if fault != nil {
+		if resp := fault(pdu); resp != nil {
+			return resp
+		}
+	}
+	exception := func(code byte) []byte {
+		return exceptionPDU(function, code)
+	}
+	switch function {
+	case modbus.FuncCodeReadHoldingRegisters:
+		addr, quantity := binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+		resp := []byte{function, byte(2 * quantity)}
+		for i := uint16(0); i < quantity; i++ {
+			if d.isIllegal(addr + i) {
+				return exception(modbus.ExceptionCodeIllegalDataAddress)
+			}
+			resp = appendUint16(resp, d.register(addr+i))
+		}
+		return resp
+	case modbus.FuncCodeWriteSingleRegister:
+		addr := binary.BigEndian.Uint16(pdu[1:])
+		if d.isIllegal(addr) {
+			return exception(modbus.ExceptionCodeIllegalDataAddress)
+		}
+		d.setRegister(addr, binary.BigEndian.Uint16(pdu[3:]))
+		return append([]byte(nil), pdu[:5]...)
+	case modbus.FuncCodeWriteMultipleRegisters:
+		addr, quantity := binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+		for i := uint16(0); i < quantity; i++ {
+			if d.isIllegal(addr + i) {
+				return exception(modbus.ExceptionCodeIllegalDataAddress)
+			}
+			d.setRegister(addr+i, binary.BigEndian.Uint16(pdu[6+2*i:]))
+		}
+		return append([]byte(nil), pdu[:5]...)
+	case modbus.FuncCodeReadFIFOQueue:
+		// The FIFO holds the registers from its address, up to 2 values
+		addr := binary.BigEndian.Uint16(pdu[1:])
+		resp := []byte{function, 0, 6, 0, 2}
+		resp = appendUint16(resp, d.register(addr))
+		return appendUint16(resp, d.register(addr+1))
+	case funcCodeDiagnostics:
+		// Return query data, the echo of the request
+		return append([]byte(nil), pdu...)
+	}
+	return exception(modbus.ExceptionCodeIllegalFunction)
+}
+
+// exceptionPDU the exception response PDU to the function
+func exceptionPDU(function, code byte) []byte {
+	return []byte{function | 0x80, code}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// mbapResponse the MBAP frame of the response PDU to the request frame
+func mbapResponse(request, pdu []byte) []byte {
+	resp := make([]byte, mbapHeaderSize, mbapHeaderSize+len(pdu))
+	copy(resp, request[:mbapHeaderSize])
+	binary.BigEndian.PutUint16(resp[4:], uint16(len(pdu)+1))
+	return append(resp, pdu...)
+}
+
+// serveTCP serve the device with MBAP frames on a local TCP listener
+func serveTCP(t *testing.T, d *testDevice) (host string, port int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveMBAP(t, ln, d)
+}
+
+// serveMBAP serve the device with MBAP frames on the listener, closed when the test ends
+func serveMBAP(t *testing.T, ln net.Listener, d *testDevice) (host string, port int) {
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			d.mutex.Lock()
+			d.conns++
+			d.mutex.Unlock()
+			go func() {
+				defer conn.Close()
+				for {
+					request := make([]byte, mbapHeaderSize)
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					pdu := make([]byte, int(binary.BigEndian.Uint16(request[4:]))-1)
+					if _, err := io.ReadFull(conn, pdu); err != nil {
+						return
+					}
+					d.mutex.Lock()
+					d.units = append(d.units, request[6])
+					d.mutex.Unlock()
+					if _, err := conn.Write(mbapResponse(request, d.handle(pdu))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// waitFor wait until cond is true, fail the test after a while
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
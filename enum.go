@@ -0,0 +1,92 @@
+package modbusorm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// enumName get the state name of the code, UNKNOWN(n) or ErrUnknownEnum if the code is not in the enumeration
+func (m *Modbus) enumName(p PointDetails, code int) (string, error) {
+	if name, ok := p.Enum[code]; ok {
+		return name, nil
+	}
+	if m.strictEnum {
+		return "", fmt.Errorf("%w: %d", ErrUnknownEnum, code)
+	}
+	return unknownEnum(code), nil
+}
+
+// enumCode get the code of the state name
+/*
+	The codes, like "7", and the unknown codes read, like "UNKNOWN(7)", are accepted too.
+*/
+func (m *Modbus) enumCode(p PointDetails, name string) (int, error) {
+	codes := make([]int, 0, len(p.Enum))
+	for code, n := range p.Enum {
+		if n == name {
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) > 0 {
+		// The lowest code of the duplicate names, so that writes are deterministic
+		sort.Ints(codes)
+		return codes[0], nil
+	}
+	code, err := strconv.Atoi(name)
+	if err != nil {
+		if _, err = fmt.Sscanf(name, "UNKNOWN(%d)", &code); err != nil || unknownEnum(code) != name {
+			return 0, fmt.Errorf("%w: %q", ErrUnknownEnum, name)
+		}
+	}
+	return code, m.checkEnumCode(p, code)
+}
+
+// checkEnumCode check the code is in the enumeration, in strict mode
+func (m *Modbus) checkEnumCode(p PointDetails, code int) error {
+	if _, ok := p.Enum[code]; !ok && m.strictEnum {
+		return fmt.Errorf("%w: %d", ErrUnknownEnum, code)
+	}
+	return nil
+}
+
+func unknownEnum(code int) string {
+	return fmt.Sprintf("UNKNOWN(%d)", code)
+}
+
+// enumValue get the code of the value written to an enumeration point, a state name or a code
+func (m *Modbus) enumValue(p PointDetails, value reflect.Value) (int, error) {
+	switch {
+	case value.Kind() == reflect.String:
+		return m.enumCode(p, value.String())
+	case value.CanInt():
+		return int(value.Int()), m.checkEnumCode(p, int(value.Int()))
+	case value.CanUint():
+		return int(value.Uint()), m.checkEnumCode(p, int(value.Uint()))
+	}
+	return 0, fmt.Errorf("%w: %v", ErrUnknownEnum, value)
+}
+
+// enumData encode the code written to an enumeration point, the reverse of the decoding of the reads
+/*
+	The code is the value read, so the registers get (code - Offset) / Coefficient
+	in the data type and the order of the point.
+*/
+func enumData(p PointDetails, code int) ([]byte, error) {
+	coefficient := p.GetCoefficient()
+	raw := math.Round((float64(code) - p.Offset) / coefficient)
+	if int(cal(raw, coefficient)+p.Offset) != code {
+		return nil, fmt.Errorf("%w: %d can't be encoded with coefficient %v and offset %v", ErrUnknownEnum, code, coefficient, p.Offset)
+	}
+	data, err := parseFloat64ToData(raw, p.DataType, p.OrderType)
+	if err != nil {
+		return nil, err
+	}
+	r := p.addrRange()
+	if quantity := int(r.End-r.Start) + 1; len(data) != 2*quantity {
+		return nil, fmt.Errorf("value length not match, want %d, got %d", quantity, len(data)/2)
+	}
+	return data, nil
+}
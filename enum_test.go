@@ -0,0 +1,107 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEnumWrite(t *testing.T) {
+	d := newTestDevice()
+	host, port := serveTCP(t, d)
+	points := Point{
+		"mode":  {Addr: 10, Quantity: 1, Coefficient: 0.5, Offset: 10, Enum: map[int]string{20: "AUTO", 30: "MANUAL"}},
+		"state": {Addr: 12, Quantity: 2, DataType: PointDataTypeU32, OrderType: OrderTypeLittleEndian, Coefficient: 2, Enum: map[int]string{0: "STOPPED", 140000: "RUNNING"}},
+		"level": {Addr: 14, Quantity: 1, DataType: PointDataTypeS16, Offset: -5, Enum: map[int]string{-10: "LOW"}},
+	}
+	m := NewModbusTCP(host, port, points, WithTimeout(time.Second))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		point string
+		name  string
+		// regs is the registers written from the address of the point
+		regs []uint16
+	}{
+		{"mode", "MANUAL", []uint16{40}},
+		{"state", "RUNNING", []uint16{0x1170, 0x0001}},
+		{"level", "LOW", []uint16{0xFFFB}},
+	}
+	check := func(t *testing.T, point, want string, regs []uint16) {
+		t.Helper()
+		for i, reg := range regs {
+			if got := d.register(points[point].Addr + uint16(i)); got != reg {
+				t.Fatalf("register %d = %#x, want %#x", i, got, reg)
+			}
+		}
+		// The write is the reverse of the read
+		var name string
+		if err := m.GetValue(ctx, point, &name); err != nil || name != want {
+			t.Fatalf("GetValue() = %q, %v, want %q", name, err, want)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.point, func(t *testing.T) {
+			if err := m.SetValue(ctx, tt.point, tt.name); err != nil {
+				t.Fatalf("SetValue() error = %v", err)
+			}
+			check(t, tt.point, tt.name, tt.regs)
+		})
+	}
+
+	for _, tt := range tests {
+		for i := range tt.regs {
+			d.setRegister(points[tt.point].Addr+uint16(i), 0)
+		}
+	}
+	values := struct {
+		Mode  string `morm:"mode"`
+		State string `morm:"state"`
+		Level string `morm:"level"`
+	}{"MANUAL", "RUNNING", "LOW"}
+	if err := m.SetValues(ctx, &values); err != nil {
+		t.Fatalf("SetValues() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run("SetValues "+tt.point, func(t *testing.T) {
+			check(t, tt.point, tt.name, tt.regs)
+		})
+	}
+}
+
+func TestEnumData(t *testing.T) {
+	tests := []struct {
+		name    string
+		point   PointDetails
+		code    int
+		want    []byte
+		wantErr error
+	}{
+		{"u16", PointDetails{Quantity: 1}, 7, []byte{0, 7}, nil},
+		{"scaled", PointDetails{Quantity: 1, Coefficient: 0.1, Offset: 1}, 3, []byte{0, 20}, nil},
+		{"s32 big endian", PointDetails{Quantity: 2, DataType: PointDataTypeS32}, -2, []byte{0xFF, 0xFF, 0xFF, 0xFE}, nil},
+		{"u32 little endian", PointDetails{Quantity: 2, DataType: PointDataTypeU32, OrderType: OrderTypeLittleEndian}, 0x10002, []byte{0, 2, 0, 1}, nil},
+		{"not a multiple of the coefficient", PointDetails{Quantity: 1, Coefficient: 2}, 3, nil, ErrUnknownEnum},
+		{"out of range", PointDetails{Quantity: 1, Offset: 10}, 0, nil, nil},
+		{"registers not match", PointDetails{Quantity: 2}, 1, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enumData(tt.point, tt.code)
+			if tt.want == nil {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("enumData() = %v, %v, want error %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != string(tt.want) {
+				t.Fatalf("enumData() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
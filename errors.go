@@ -1,8 +1,137 @@
 package modbusorm
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	ErrPoolClosed = errors.New("modbus pool is closed")
 	ErrFactoryNil = errors.New("factory cannot be nil")
+	// ErrDeviceUnavailable is matched by the errors of the requests rejected by an open circuit breaker
+	ErrDeviceUnavailable = errors.New("modbus device is unavailable")
+	// ErrCacheMiss is reported for the points whose cached registers were invalidated while they were read
+	ErrCacheMiss = errors.New("modbus cached registers are invalidated")
+	// ErrRequestRejected is matched by the errors of the requests an interceptor returned without sending them to the device
+	ErrRequestRejected = errors.New("modbus request rejected by an interceptor")
+	// ErrUnknownEnum is matched by the errors of the codes and state names not in the enumeration of a point
+	ErrUnknownEnum = errors.New("modbus enumeration value is unknown")
 )
+
+// PointError error of a single point
+type PointError struct {
+	Point string
+	Err   error
+}
+
+func (e *PointError) Error() string {
+	return fmt.Sprintf("point %s: %v", e.Point, e.Err)
+}
+
+func (e *PointError) Unwrap() error {
+	return e.Err
+}
+
+// ValuesError errors of all failed points, returned in partial results mode
+/*
+	Fields of points not listed in Errors are filled with the values read.
+*/
+type ValuesError struct {
+	Errors []*PointError
+}
+
+func (e *ValuesError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, pe := range e.Errors {
+		msgs = append(msgs, pe.Error())
+	}
+	return fmt.Sprintf("%d points failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Failed return the failed point names
+func (e *ValuesError) Failed() []string {
+	points := make([]string, 0, len(e.Errors))
+	for _, pe := range e.Errors {
+		points = append(points, pe.Point)
+	}
+	return points
+}
+
+// add record the error of a point
+func (e *ValuesError) add(point string, err error) {
+	e.Errors = append(e.Errors, &PointError{Point: point, Err: err})
+}
+
+// merge merge the point errors in err, return false if err is not a ValuesError
+func (e *ValuesError) merge(err error) bool {
+	var ve *ValuesError
+	if !errors.As(err, &ve) {
+		return false
+	}
+	e.Errors = append(e.Errors, ve.Errors...)
+	return true
+}
+
+// errOrNil return nil if no point failed
+func (e *ValuesError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// multiError errors of several attempts failed, matched by errors.Is and errors.As as any of them
+type multiError struct {
+	errs []error
+}
+
+// joinErrors join the errors not nil, the same messages once
+func joinErrors(errs ...error) error {
+	e := &multiError{}
+	seen := make(map[string]bool)
+	for _, err := range errs {
+		if err == nil || seen[err.Error()] {
+			continue
+		}
+		seen[err.Error()] = true
+		e.errs = append(e.errs, err)
+	}
+	switch len(e.errs) {
+	case 0:
+		return nil
+	case 1:
+		return e.errs[0]
+	}
+	return e
+}
+
+func (e *multiError) Error() string {
+	msgs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *multiError) Unwrap() []error {
+	return e.errs
+}
+
+func (e *multiError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *multiError) As(target any) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
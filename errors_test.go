@@ -0,0 +1,29 @@
+package modbusorm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJoinErrors(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	pointErr := &PointError{Point: "power", Err: errB}
+
+	if err := joinErrors(nil, nil); err != nil {
+		t.Fatalf("joinErrors(nil) = %v", err)
+	}
+	if err := joinErrors(errA, nil, errors.New("a")); err != errA {
+		t.Fatalf("joinErrors() of the same message = %v, want it once", err)
+	}
+	err := joinErrors(errA, pointErr)
+	if err.Error() != "a; point power: b" {
+		t.Fatalf("Error() = %q", err.Error())
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errB) || errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("errors.Is() of %v does not match the errors joined", err)
+	}
+	var pe *PointError
+	if !errors.As(err, &pe) || pe != pointErr {
+		t.Fatalf("errors.As() = %v, want the point error", pe)
+	}
+}
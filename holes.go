@@ -0,0 +1,141 @@
+package modbusorm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// learnedHoles unreadable address ranges learned per connection target
+/*
+	A hole is a gap between the requested points that makes the device reject the whole block,
+	such as reserved addresses. Once learned, blocks will never bridge it,
+	until it expires by WithHoleTTL or is forgotten by ResetHoles.
+*/
+var learnedHoles = struct {
+	sync.Mutex
+	targets  map[string][]learnedHole
+	versions map[string]int
+}{targets: map[string][]learnedHole{}, versions: map[string]int{}}
+
+// learnedHole a hole and the last time it was learned
+type learnedHole struct {
+	AddrRange
+	learnedAt time.Time
+}
+
+// learnHole remember the hole of the target
+func learnHole(target string, hole AddrRange) {
+	learnedHoles.Lock()
+	defer learnedHoles.Unlock()
+
+	holes := append(learnedHoles.targets[target], learnedHole{AddrRange: hole, learnedAt: time.Now()})
+	sort.Slice(holes, func(i, j int) bool { return holes[i].Start < holes[j].Start })
+
+	// Merge the overlapping or adjacent holes
+	merged := holes[:1]
+	for _, h := range holes[1:] {
+		last := &merged[len(merged)-1]
+		if uint32(h.Start) <= uint32(last.End)+1 {
+			if h.End > last.End {
+				last.End = h.End
+			}
+			if h.learnedAt.After(last.learnedAt) {
+				last.learnedAt = h.learnedAt
+			}
+			continue
+		}
+		merged = append(merged, h)
+	}
+	learnedHoles.targets[target] = merged
+	learnedHoles.versions[target]++
+}
+
+// expireHoles forget the holes of the target learned ttl ago, ttl 0 means never, the caller must hold the lock
+func expireHoles(target string, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	holes := learnedHoles.targets[target]
+	kept := holes[:0]
+	for _, h := range holes {
+		if time.Since(h.learnedAt) < ttl {
+			kept = append(kept, h)
+		}
+	}
+	if len(kept) != len(holes) {
+		learnedHoles.targets[target] = kept
+		learnedHoles.versions[target]++
+	}
+}
+
+// getHoles get the holes of the target not expired
+func getHoles(target string, ttl time.Duration) []AddrRange {
+	learnedHoles.Lock()
+	defer learnedHoles.Unlock()
+
+	expireHoles(target, ttl)
+	holes := make([]AddrRange, 0, len(learnedHoles.targets[target]))
+	for _, h := range learnedHoles.targets[target] {
+		holes = append(holes, h.AddrRange)
+	}
+	return holes
+}
+
+// holesVersion get the version of the holes of the target, changed whenever a hole is learned or forgotten
+func holesVersion(target string, ttl time.Duration) int {
+	learnedHoles.Lock()
+	defer learnedHoles.Unlock()
+
+	expireHoles(target, ttl)
+	return learnedHoles.versions[target]
+}
+
+// resetHoles forget the holes of the target
+func resetHoles(target string) {
+	learnedHoles.Lock()
+	defer learnedHoles.Unlock()
+
+	delete(learnedHoles.targets, target)
+	learnedHoles.versions[target]++
+}
+
+// Holes Get the unreadable address ranges learned from the device
+func (m *Modbus) Holes() []AddrRange {
+	return getHoles(m.target(), m.holeTTL)
+}
+
+// ResetHoles Forget the unreadable address ranges learned from the device
+/*
+	Like after a firmware update of the device, or a transient exception taken for a hole,
+	the next reads plan the blocks across the gaps again.
+*/
+func (m *Modbus) ResetHoles() {
+	resetHoles(m.target())
+}
+
+// forbiddenRanges get the ranges must never be read, declared by option and by point table
+func (m *Modbus) forbiddenRanges() []AddrRange {
+	ranges := append([]AddrRange{}, m.forbidden...)
+	for _, p := range m.points {
+		if p.Forbidden {
+			ranges = append(ranges, p.addrRange())
+		}
+	}
+	return ranges
+}
+
+// unreadableRanges get the forbidden ranges and the learned holes
+func (m *Modbus) unreadableRanges() []AddrRange {
+	return append(m.forbiddenRanges(), getHoles(m.target(), m.holeTTL)...)
+}
+
+// bridgesHole check if reading from start to end would bridge an unreadable range
+func bridgesHole(holes []AddrRange, start, end uint16) bool {
+	for _, h := range holes {
+		if h.overlaps(start, end) {
+			return true
+		}
+	}
+	return false
+}
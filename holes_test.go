@@ -0,0 +1,171 @@
+package modbusorm
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// readLog record the reads of the device, and reject those of more than maxQuantity registers
+type readLog struct {
+	mutex       sync.Mutex
+	reads       []AddrRange
+	maxQuantity uint16
+}
+
+func (l *readLog) fault(pdu []byte) []byte {
+	if pdu[0] != modbus.FuncCodeReadHoldingRegisters {
+		return nil
+	}
+	addr, quantity := binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.reads = append(l.reads, AddrRange{Start: addr, End: addr + quantity - 1})
+	if l.maxQuantity > 0 && quantity > l.maxQuantity {
+		return exceptionPDU(pdu[0], modbus.ExceptionCodeIllegalDataAddress)
+	}
+	return nil
+}
+
+// take get the reads recorded and forget them
+func (l *readLog) take() []AddrRange {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	reads := l.reads
+	l.reads = nil
+	return reads
+}
+
+func newHolesTestModbus(t *testing.T, d *testDevice, points Point, opts ...ModbusOption) *Modbus {
+	host, port := serveTCP(t, d)
+	opts = append([]ModbusOption{WithTimeout(time.Second), WithBlock(true), WithRequestDelay(0)}, opts...)
+	m := NewModbusTCP(host, port, points, opts...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestSplitBlockAtPointBoundaries(t *testing.T) {
+	d := newTestDevice()
+	log := &readLog{maxQuantity: 2}
+	d.setFault(log.fault)
+	points := Point{
+		"energy": {Addr: 10, Quantity: 2, DataType: PointDataTypeU32},
+		"power":  {Addr: 12, Quantity: 1},
+		"total":  {Addr: 13, Quantity: 2, DataType: PointDataTypeU32},
+	}
+	m := newHolesTestModbus(t, d, points)
+
+	var v struct {
+		Energy uint32 `morm:"energy"`
+		Power  uint16 `morm:"power"`
+		Total  uint32 `morm:"total"`
+	}
+	if err := m.GetValues(context.Background(), &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if v.Energy != 10<<16|11 || v.Power != 12 || v.Total != 13<<16|14 {
+		t.Fatalf("GetValues() = %+v", v)
+	}
+	want := []AddrRange{{10, 14}, {10, 11}, {12, 14}, {12, 12}, {13, 14}}
+	if reads := log.take(); !reflect.DeepEqual(reads, want) {
+		t.Fatalf("reads = %v, want %v", reads, want)
+	}
+	if holes := m.Holes(); len(holes) != 0 {
+		t.Fatalf("Holes() = %v, want none between adjacent points", holes)
+	}
+}
+
+func TestSplitBlockSinglePoint(t *testing.T) {
+	d := newTestDevice()
+	log := &readLog{maxQuantity: 1}
+	d.setFault(log.fault)
+	m := newHolesTestModbus(t, d, Point{"energy": {Addr: 10, Quantity: 2, DataType: PointDataTypeU32}})
+
+	// A point is never read in parts
+	var v struct {
+		Energy uint32 `morm:"energy"`
+	}
+	if code, ok := ExceptionCode(m.GetValues(context.Background(), &v)); !ok || code != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("GetValues() exception = %d, %v, want illegal data address", code, ok)
+	}
+	if reads := log.take(); !reflect.DeepEqual(reads, []AddrRange{{10, 11}}) {
+		t.Fatalf("reads = %v, want the point only", reads)
+	}
+}
+
+type holeValues struct {
+	A uint16 `morm:"a"`
+	B uint16 `morm:"b"`
+}
+
+var holePoints = Point{
+	"a": {Addr: 10, Quantity: 1},
+	"b": {Addr: 20, Quantity: 1},
+}
+
+func TestLearnHole(t *testing.T) {
+	d := newTestDevice(15)
+	log := &readLog{}
+	d.setFault(log.fault)
+	m := newHolesTestModbus(t, d, holePoints)
+	ctx := context.Background()
+
+	var v holeValues
+	if err := m.GetValues(ctx, &v); err != nil || v.A != 10 || v.B != 20 {
+		t.Fatalf("GetValues() = %+v, %v", v, err)
+	}
+	if holes := m.Holes(); !reflect.DeepEqual(holes, []AddrRange{{11, 19}}) {
+		t.Fatalf("Holes() = %v, want the gap", holes)
+	}
+	if reads := log.take(); !reflect.DeepEqual(reads, []AddrRange{{10, 20}, {10, 10}, {20, 20}}) {
+		t.Fatalf("reads = %v", reads)
+	}
+
+	// The hole is not bridged again
+	if err := m.GetValues(ctx, &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if reads := log.take(); !reflect.DeepEqual(reads, []AddrRange{{10, 10}, {20, 20}}) {
+		t.Fatalf("reads after the hole is learned = %v", reads)
+	}
+
+	// Once forgotten, the gap is read again, like after a firmware update
+	d.setIllegal(15, false)
+	m.ResetHoles()
+	if holes := m.Holes(); len(holes) != 0 {
+		t.Fatalf("Holes() after ResetHoles = %v", holes)
+	}
+	if err := m.GetValues(ctx, &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if reads := log.take(); !reflect.DeepEqual(reads, []AddrRange{{10, 20}}) {
+		t.Fatalf("reads after ResetHoles = %v, want one block", reads)
+	}
+}
+
+func TestHoleTTL(t *testing.T) {
+	d := newTestDevice(15)
+	m := newHolesTestModbus(t, d, holePoints, WithHoleTTL(50*time.Millisecond))
+
+	var v holeValues
+	if err := m.GetValues(context.Background(), &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if holes := m.Holes(); len(holes) != 1 {
+		t.Fatalf("Holes() = %v, want the gap", holes)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if holes := m.Holes(); len(holes) != 0 {
+		t.Fatalf("Holes() after the ttl = %v, want none", holes)
+	}
+}
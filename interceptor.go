@@ -0,0 +1,127 @@
+package modbusorm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/goburrow/modbus"
+)
+
+// Request a modbus request sent by the ORM
+type Request struct {
+	FunctionCode byte
+	Address      uint16
+	Quantity     uint16
+	// Payload is the register values of a write, big endian
+	Payload []byte
+	SlaveID uint8
+	// Points is the names of the points the request serves
+	Points []string
+	// Attempt is the attempt of the request, from 1, see RetryPolicy
+	Attempt int
+	// Idempotent allows a write to be retried
+	Idempotent bool
+}
+
+// IsWrite check if the request changes the device
+func (r Request) IsWrite() bool {
+	switch r.FunctionCode {
+	case modbus.FuncCodeReadCoils,
+		modbus.FuncCodeReadDiscreteInputs,
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeReadInputRegisters,
+		modbus.FuncCodeReadFIFOQueue,
+		funcCodeDiagnostics:
+		return false
+	}
+	return true
+}
+
+// Response the response of a modbus request
+type Response struct {
+	// Data is the register values of a read, or the echo of a write
+	Data []byte
+}
+
+// Invoker send the request to the device
+type Invoker func(ctx context.Context, req Request) (Response, error)
+
+// Interceptor wrap each request sent to the device, it must call next to send the request
+/*
+	Like auditing, rate limiting or fault injection, an interceptor can change the request,
+	or return without calling next. Each attempt of a retried request is intercepted.
+*/
+type Interceptor func(ctx context.Context, req Request, next Invoker) (Response, error)
+
+// RejectedError the error of a request an interceptor returned without sending it to the device
+/*
+	Like the errors of a rate limiter or an authorization check, a rejection says nothing about the device:
+	it is not retried by IsRetryable, not counted by the circuit breaker, and the connection is kept.
+	An exception returned by an interceptor is handled like an exception of the device, for fault injection.
+*/
+type RejectedError struct {
+	Err error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRequestRejected, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Is make errors.Is(err, ErrRequestRejected) true
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRequestRejected
+}
+
+// rejected wrap the error returned by the interceptors as a rejection, unless the device returned an error
+func rejected(err error, reached bool, deviceErr error) error {
+	var mbErr *modbus.ModbusError
+	if err == nil || (reached && deviceErr != nil) || errors.As(err, &mbErr) {
+		return err
+	}
+	return &RejectedError{Err: err}
+}
+
+// chainInterceptors build the invoker calling the interceptors in order, the first is the outermost
+func chainInterceptors(interceptors []Interceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, req Request) (Response, error) {
+			return interceptor(ctx, req, next)
+		}
+	}
+	return invoker
+}
+
+// invoke send the request with the client
+func invoke(conn Client, req Request) (Response, error) {
+	conn.SetSlaveID(req.SlaveID)
+
+	var data []byte
+	var err error
+	switch req.FunctionCode {
+	case modbus.FuncCodeReadHoldingRegisters:
+		data, err = conn.ReadHoldingRegisters(req.Address, req.Quantity)
+	case modbus.FuncCodeReadInputRegisters:
+		data, err = conn.ReadInputRegisters(req.Address, req.Quantity)
+	case modbus.FuncCodeReadCoils:
+		data, err = conn.ReadCoils(req.Address, req.Quantity)
+	case modbus.FuncCodeReadDiscreteInputs:
+		data, err = conn.ReadDiscreteInputs(req.Address, req.Quantity)
+	case modbus.FuncCodeWriteSingleRegister:
+		if len(req.Payload) != 2 {
+			return Response{}, fmt.Errorf("payload of write single register must be 2 bytes, got %d", len(req.Payload))
+		}
+		data, err = conn.WriteSingleRegister(req.Address, binary.BigEndian.Uint16(req.Payload))
+	case modbus.FuncCodeWriteMultipleRegisters:
+		data, err = conn.WriteMultipleRegisters(req.Address, req.Quantity, req.Payload)
+	default:
+		return Response{}, fmt.Errorf("function code %d is not supported", req.FunctionCode)
+	}
+	return Response{Data: data}, err
+}
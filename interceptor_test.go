@@ -0,0 +1,120 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+var errRateLimited = errors.New("rate limited")
+
+func newInterceptorTestModbus(t *testing.T, interceptor Interceptor) *Modbus {
+	host, port := serveTCP(t, newTestDevice())
+	points := Point{"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1}}
+	m := NewModbusTCP(host, port, points,
+		WithTimeout(time.Second),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}),
+		WithCircuitBreaker(BreakerConfig{Failures: 1, OpenTimeout: time.Minute}),
+		WithInterceptor(interceptor),
+	)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestInterceptorRejection(t *testing.T) {
+	var calls int
+	reject := true
+	m := newInterceptorTestModbus(t, func(ctx context.Context, req Request, next Invoker) (Response, error) {
+		calls++
+		if reject {
+			return Response{}, errRateLimited
+		}
+		return next(ctx, req)
+	})
+	ctx := context.Background()
+
+	var voltage float64
+	err := m.GetValue(ctx, "voltage", &voltage)
+	if !errors.Is(err, ErrRequestRejected) || !errors.Is(err, errRateLimited) {
+		t.Fatalf("GetValue() error = %v, want a rejection", err)
+	}
+	// A rejection is not retried and says nothing about the device
+	if calls != 1 {
+		t.Fatalf("interceptor called %d times, want 1", calls)
+	}
+	if got := m.BreakerState(); got != BreakerClosed {
+		t.Fatalf("BreakerState() = %v, want closed", got)
+	}
+	if retries := m.RetryStats().Retries; retries != 0 {
+		t.Fatalf("RetryStats().Retries = %d, want 0", retries)
+	}
+
+	reject = false
+	if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 1 {
+		t.Fatalf("GetValue() after the rejection = %v, %v, want 1", voltage, err)
+	}
+}
+
+func TestInterceptorInjectedException(t *testing.T) {
+	var calls int
+	m := newInterceptorTestModbus(t, func(ctx context.Context, req Request, next Invoker) (Response, error) {
+		calls++
+		if req.Attempt == 1 {
+			return Response{}, &modbus.ModbusError{FunctionCode: req.FunctionCode, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}
+		}
+		return next(ctx, req)
+	})
+
+	// An injected exception is handled like an exception of the device
+	var voltage float64
+	if err := m.GetValue(context.Background(), "voltage", &voltage); err != nil || voltage != 1 {
+		t.Fatalf("GetValue() = %v, %v, want 1", voltage, err)
+	}
+	if calls != 2 {
+		t.Fatalf("interceptor called %d times, want 2", calls)
+	}
+	if retries := m.RetryStats().Retries; retries != 1 {
+		t.Fatalf("RetryStats().Retries = %d, want 1", retries)
+	}
+}
+
+func TestErrorClasses(t *testing.T) {
+	rejection := &RejectedError{Err: errRateLimited}
+	if isTransportError(rejection) {
+		t.Error("isTransportError() of a rejection = true")
+	}
+	if IsRetryable(rejection) {
+		t.Error("IsRetryable() of a rejection = true")
+	}
+	if !isTransportError(io.EOF) || !IsRetryable(io.EOF) {
+		t.Error("a closed connection is not a retryable transport error")
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		reached   bool
+		deviceErr error
+		want      bool
+	}{
+		{"interceptor error", errRateLimited, false, nil, true},
+		{"interceptor error after a success", errRateLimited, true, nil, true},
+		{"device error", io.EOF, true, io.EOF, false},
+		{"injected exception", &modbus.ModbusError{ExceptionCode: modbus.ExceptionCodeServerDeviceBusy}, false, nil, false},
+		{"no error", nil, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(rejected(tt.err, tt.reached, tt.deviceErr), ErrRequestRejected); got != tt.want {
+				t.Fatalf("rejected() is a rejection = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
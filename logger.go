@@ -0,0 +1,55 @@
+package modbusorm
+
+// Logger structured logger, args are key-value pairs, *slog.Logger implements it
+/*
+	The keys are consistent across the messages:
+	target, slave_id, function_code, address, quantity, duration, attempt, backoff,
+	point, blocks, type, reason, from, to, frame_delay, group, missed and error.
+*/
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// nopLogger the default logger, logs nothing
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+// fieldLogger add the fields to each message
+type fieldLogger struct {
+	logger Logger
+	fields []any
+}
+
+// withFields get a logger adding the key-value pairs to each message
+func withFields(logger Logger, fields ...any) Logger {
+	if _, ok := logger.(nopLogger); ok {
+		return logger
+	}
+	if l, ok := logger.(*fieldLogger); ok {
+		return &fieldLogger{logger: l.logger, fields: append(append([]any{}, l.fields...), fields...)}
+	}
+	return &fieldLogger{logger: logger, fields: fields}
+}
+
+func (l *fieldLogger) Debug(msg string, args ...any) {
+	l.logger.Debug(msg, append(append([]any{}, l.fields...), args...)...)
+}
+
+func (l *fieldLogger) Info(msg string, args ...any) {
+	l.logger.Info(msg, append(append([]any{}, l.fields...), args...)...)
+}
+
+func (l *fieldLogger) Warn(msg string, args ...any) {
+	l.logger.Warn(msg, append(append([]any{}, l.fields...), args...)...)
+}
+
+func (l *fieldLogger) Error(msg string, args ...any) {
+	l.logger.Error(msg, append(append([]any{}, l.fields...), args...)...)
+}
@@ -0,0 +1,156 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// logEntry a message logged, with its fields
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]any
+}
+
+// recordLogger record the messages logged
+type recordLogger struct {
+	mutex   sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordLogger) log(level, msg string, args []any) {
+	fields := make(map[string]any)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[args[i].(string)] = args[i+1]
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.log("debug", msg, args) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.log("info", msg, args) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.log("warn", msg, args) }
+func (l *recordLogger) Error(msg string, args ...any) { l.log("error", msg, args) }
+
+// find get the first message logged
+func (l *recordLogger) find(msg string) (logEntry, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, e := range l.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+// stall answer after the timeout of the observed requests, like a device gone silent
+func stall([]byte) []byte {
+	time.Sleep(200 * time.Millisecond)
+	return nil
+}
+
+// outcomes the outcomes of a request, reported to the logger, the metrics and the tracer
+var outcomes = []struct {
+	name  string
+	fault func(pdu []byte) []byte
+}{
+	{"success", nil},
+	{"exception", deviceFailure},
+	{"transport error", stall},
+}
+
+// newObservedModbus a modbus reading the point power, at address 10, from a device failing with fault
+func newObservedModbus(t *testing.T, fault func(pdu []byte) []byte, opts ...ModbusOption) *Modbus {
+	d := newTestDevice()
+	d.setFault(fault)
+	host, port := serveTCP(t, d)
+	opts = append([]ModbusOption{WithTimeout(50 * time.Millisecond)}, opts...)
+	m := NewModbusTCP(host, port, Point{"power": {Addr: 10, Quantity: 1}}, opts...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestLoggerRequest(t *testing.T) {
+	for _, tt := range outcomes {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			m := newObservedModbus(t, tt.fault, WithLogger(logger))
+			var v uint16
+			err := m.GetValue(context.Background(), "power", &v)
+			if (err == nil) != (tt.fault == nil) {
+				t.Fatalf("GetValue() error = %v", err)
+			}
+
+			msg := "modbus request"
+			if err != nil {
+				msg = "modbus request failed"
+			}
+			e, ok := logger.find(msg)
+			if !ok {
+				t.Fatalf("%q not logged, got %+v", msg, logger.entries)
+			}
+			want := map[string]any{
+				"target":        m.target(),
+				"slave_id":      uint8(1),
+				"function_code": byte(modbus.FuncCodeReadHoldingRegisters),
+				"address":       uint16(10),
+				"quantity":      uint16(1),
+				"attempt":       1,
+			}
+			for key, value := range want {
+				if !reflect.DeepEqual(e.fields[key], value) {
+					t.Errorf("%s = %#v, want %#v", key, e.fields[key], value)
+				}
+			}
+			if d, ok := e.fields["duration"].(time.Duration); e.level != "debug" || !ok || d <= 0 {
+				t.Errorf("%q logged at %s with duration %v", msg, e.level, e.fields["duration"])
+			}
+			// The error of the device, wrapped by the call
+			logged, _ := e.fields["error"].(error)
+			if (logged == nil) != (err == nil) || !errors.Is(err, logged) {
+				t.Errorf("error = %v, want the cause of %v", logged, err)
+			}
+			if code, ok := ExceptionCode(logged); tt.name == "exception" && (!ok || code != modbus.ExceptionCodeServerDeviceFailure) {
+				t.Errorf("error = %v, want the exception", logged)
+			}
+			if tt.name == "transport error" && !isTransportError(logged) {
+				t.Errorf("error = %v, want a transport error", logged)
+			}
+		})
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	if l := withFields(nopLogger{}, "target", "t"); l != (nopLogger{}) {
+		t.Fatalf("withFields() of the nop logger = %T, want it kept", l)
+	}
+	logger := &recordLogger{}
+	device := withFields(logger, "target", "t")
+	a := withFields(device, "point", "a")
+	b := withFields(device, "point", "b")
+	a.Info("msg", "k", 1)
+	b.Warn("msg")
+	device.Error("msg")
+
+	want := []logEntry{
+		{"info", "msg", map[string]any{"target": "t", "point": "a", "k": 1}},
+		{"warn", "msg", map[string]any{"target": "t", "point": "b"}},
+		{"error", "msg", map[string]any{"target": "t"}},
+	}
+	if !reflect.DeepEqual(logger.entries, want) {
+		t.Fatalf("entries = %+v, want %+v", logger.entries, want)
+	}
+}
@@ -0,0 +1,116 @@
+package modbusorm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// Metrics receive the measurements of the modbus, the modbusprom module exposes them as Prometheus collectors
+/*
+	target is the target of the device, like tcp://192.168.1.10:502/1,
+	the pools are keyed by the transport, like tcp://192.168.1.10:502, shared by the devices behind it.
+	The methods are called concurrently, and must not block.
+*/
+type Metrics interface {
+	// ObserveRequest is called after each attempt of a request
+	ObserveRequest(target string, obs RequestObservation)
+	// ObserveBlocks is called after the blocks of a GetValuesBlock call are read,
+	// with the registers actually read and the registers of the points
+	ObserveBlocks(target string, registersRead, registersNeeded int)
+	// AddPool is called when the transport is opened, stats is called to get the pool statistics
+	AddPool(transport string, stats func() PoolStats)
+	// RemovePool is called when the transport is closed
+	RemovePool(transport string)
+}
+
+// RequestObservation the measurement of a request attempt
+type RequestObservation struct {
+	Request  Request
+	Duration time.Duration
+	// BytesSent and BytesReceived are the size of the PDUs, without the framing of the transport
+	BytesSent     int
+	BytesReceived int
+	Err           error
+}
+
+// nopMetrics the default metrics, measures nothing
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveRequest(target string, obs RequestObservation)            {}
+func (nopMetrics) ObserveBlocks(target string, registersRead, registersNeeded int) {}
+func (nopMetrics) AddPool(transport string, stats func() PoolStats)                {}
+func (nopMetrics) RemovePool(transport string)                                     {}
+
+// ExceptionCode get the exception code of the modbus exception response in err
+func ExceptionCode(err error) (byte, bool) {
+	var mbErr *modbus.ModbusError
+	if !errors.As(err, &mbErr) {
+		return 0, false
+	}
+	return mbErr.ExceptionCode, true
+}
+
+// pduSizes the size of the request and response PDUs, the response is empty on transport errors
+func pduSizes(req Request, resp Response, err error) (sent, received int) {
+	if errors.Is(err, ErrRequestRejected) {
+		// Not sent
+		return 0, 0
+	}
+	// function code, address and quantity or value
+	sent = 5
+	if req.FunctionCode == modbus.FuncCodeWriteMultipleRegisters {
+		// byte count and values
+		sent += 1 + len(req.Payload)
+	}
+	switch {
+	case err == nil && req.IsWrite():
+		// function code and the echo of address and quantity or value
+		received = 5
+	case err == nil:
+		// function code, byte count and values
+		received = 2 + len(resp.Data)
+	case !isTransportError(err):
+		// function code and exception code
+		received = 2
+	}
+	return
+}
+
+// registersNeeded the number of registers of the points in the block, overlapping points are counted once
+func registersNeeded(b *block) int {
+	covered := make([]bool, int(b.end-b.start)+1)
+	for _, r := range b.ranges {
+		for addr := int(max(r.Start, b.start)); addr <= int(min(r.End, b.end)); addr++ {
+			covered[addr-int(b.start)] = true
+		}
+	}
+	var n int
+	for _, c := range covered {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+// observeBlocks report the efficiency of the blocks read
+func (m *Modbus) observeBlocks(bs blocks) {
+	var read, needed int
+	for _, b := range bs {
+		if b.err != nil {
+			continue
+		}
+		read += int(b.end-b.start) + 1
+		needed += registersNeeded(b)
+	}
+	m.metrics.ObserveBlocks(m.target(), read, needed)
+}
+
+// transport the target of the transport, without the slave id
+func (m *Modbus) transport() string {
+	return strings.TrimSuffix(m.target(), fmt.Sprintf("/%d", m.slaveID))
+}
@@ -0,0 +1,146 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+// recordMetrics record the measurements
+type recordMetrics struct {
+	mutex    sync.Mutex
+	targets  []string
+	requests []RequestObservation
+	// blocks is the registers read and needed of each ObserveBlocks call
+	blocks [][2]int
+	pools  map[string]func() PoolStats
+}
+
+func newRecordMetrics() *recordMetrics {
+	return &recordMetrics{pools: make(map[string]func() PoolStats)}
+}
+
+func (r *recordMetrics) ObserveRequest(target string, obs RequestObservation) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.targets = append(r.targets, target)
+	r.requests = append(r.requests, obs)
+}
+
+func (r *recordMetrics) ObserveBlocks(target string, registersRead, registersNeeded int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.blocks = append(r.blocks, [2]int{registersRead, registersNeeded})
+}
+
+func (r *recordMetrics) AddPool(transport string, stats func() PoolStats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pools[transport] = stats
+}
+
+func (r *recordMetrics) RemovePool(transport string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.pools, transport)
+}
+
+func TestMetricsRequest(t *testing.T) {
+	// the read of 1 register: 5 bytes sent, and the values, an exception or nothing received
+	received := map[string]int{"success": 4, "exception": 2, "transport error": 0}
+	for _, tt := range outcomes {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := newRecordMetrics()
+			m := newObservedModbus(t, tt.fault, WithMetrics(metrics))
+			var v uint16
+			err := m.GetValue(context.Background(), "power", &v)
+
+			if len(metrics.requests) != 1 || metrics.targets[0] != m.target() {
+				t.Fatalf("requests observed = %v for %v, want 1 for %s", metrics.requests, metrics.targets, m.target())
+			}
+			obs := metrics.requests[0]
+			if req := obs.Request; req.FunctionCode != modbus.FuncCodeReadHoldingRegisters || req.Address != 10 || req.Quantity != 1 || req.Attempt != 1 {
+				t.Fatalf("Request = %+v", req)
+			}
+			if obs.Duration <= 0 || obs.BytesSent != 5 || obs.BytesReceived != received[tt.name] {
+				t.Fatalf("observed %v, %d bytes sent, %d received, want 5 sent and %d received", obs.Duration, obs.BytesSent, obs.BytesReceived, received[tt.name])
+			}
+			if (obs.Err == nil) != (err == nil) || !errors.Is(err, obs.Err) {
+				t.Fatalf("Err = %v, want the cause of %v", obs.Err, err)
+			}
+		})
+	}
+}
+
+func TestMetricsBlocksAndPool(t *testing.T) {
+	metrics := newRecordMetrics()
+	d := newTestDevice()
+	host, port := serveTCP(t, d)
+	points := Point{
+		"power":  {Addr: 10, Quantity: 1},
+		"energy": {Addr: 12, Quantity: 2, DataType: PointDataTypeU32},
+	}
+	m := NewModbusTCP(host, port, points, WithBlock(true), WithMetrics(metrics))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	// The pool is shared by the devices behind the transport
+	transport := fmt.Sprintf("tcp://%s:%d", host, port)
+	if _, ok := metrics.pools[transport]; !ok {
+		t.Fatalf("pools = %v, want %s added", metrics.pools, transport)
+	}
+
+	var v struct {
+		Power  uint16 `morm:"power"`
+		Energy uint32 `morm:"energy"`
+	}
+	if err := m.GetValues(context.Background(), &v); err != nil {
+		t.Fatal(err)
+	}
+	// The gap at 11 is read with the points
+	if len(metrics.blocks) != 1 || metrics.blocks[0] != [2]int{4, 3} {
+		t.Fatalf("blocks observed = %v, want 4 registers read for 3 needed", metrics.blocks)
+	}
+
+	m.Close()
+	if len(metrics.pools) != 0 {
+		t.Fatalf("pools after Close = %v, want removed", metrics.pools)
+	}
+}
+
+func TestPDUSizes(t *testing.T) {
+	exception := &modbus.ModbusError{FunctionCode: modbus.FuncCodeReadHoldingRegisters, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+	read := Request{FunctionCode: modbus.FuncCodeReadHoldingRegisters, Quantity: 2}
+	writeSingle := Request{FunctionCode: modbus.FuncCodeWriteSingleRegister, Quantity: 1, Payload: []byte{0, 1}}
+	writeMultiple := Request{FunctionCode: modbus.FuncCodeWriteMultipleRegisters, Quantity: 2, Payload: []byte{0, 1, 0, 2}}
+	tests := []struct {
+		name           string
+		req            Request
+		resp           Response
+		err            error
+		sent, received int
+	}{
+		{"read", read, Response{Data: []byte{0, 1, 0, 2}}, nil, 5, 6},
+		{"read exception", read, Response{}, exception, 5, 2},
+		{"read transport error", read, Response{}, io.EOF, 5, 0},
+		{"write single", writeSingle, Response{}, nil, 5, 5},
+		{"write multiple", writeMultiple, Response{}, nil, 10, 5},
+		{"write multiple exception", writeMultiple, Response{}, exception, 10, 2},
+		{"rejected", writeMultiple, Response{}, ErrRequestRejected, 0, 0},
+	}
+	for _, tt := range tests {
+		sent, received := pduSizes(tt.req, tt.resp, tt.err)
+		if sent != tt.sent || received != tt.received {
+			t.Errorf("pduSizes(%s) = %d, %d, want %d, %d", tt.name, sent, received, tt.sent, tt.received)
+		}
+	}
+}
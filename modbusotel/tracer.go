@@ -0,0 +1,84 @@
+// Package modbusotel trace the operations of modbus-orm with OpenTelemetry
+/*
+	m := modbusorm.NewModbusTCP(host, port, point, modbusorm.WithTracer(modbusotel.NewTracer(nil)))
+*/
+package modbusotel
+
+import (
+	"context"
+	"fmt"
+
+	modbusorm "github.com/TwoMental/modbus-orm"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName the name of the OpenTelemetry tracer
+const instrumentationName = "github.com/TwoMental/modbus-orm"
+
+// Tracer implements modbusorm.Tracer with an OpenTelemetry tracer
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ modbusorm.Tracer = (*Tracer)(nil)
+
+// NewTracer allocates a Tracer, the global tracer provider is used if provider is nil
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: provider.Tracer(instrumentationName)}
+}
+
+// Start implements modbusorm.Tracer, the requests sent to the device are client spans
+func (t *Tracer) Start(ctx context.Context, name string, attrs ...modbusorm.Attribute) (context.Context, modbusorm.Span) {
+	kind := trace.SpanKindInternal
+	if name == "modbus.request" {
+		kind = trace.SpanKindClient
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(convert(attrs)...))
+	return ctx, &Span{span: span}
+}
+
+// Span implements modbusorm.Span with an OpenTelemetry span
+type Span struct {
+	span trace.Span
+}
+
+// SetAttributes implements modbusorm.Span
+func (s *Span) SetAttributes(attrs ...modbusorm.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+// End implements modbusorm.Span, the error is recorded and sets the status of the span
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// convert convert the attributes to OpenTelemetry attributes
+func convert(attrs []modbusorm.Attribute) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		key := attribute.Key(a.Key)
+		switch v := a.Value.(type) {
+		case string:
+			kvs = append(kvs, key.String(v))
+		case int:
+			kvs = append(kvs, key.Int(v))
+		case bool:
+			kvs = append(kvs, key.Bool(v))
+		case []string:
+			kvs = append(kvs, key.StringSlice(v))
+		default:
+			kvs = append(kvs, key.String(fmt.Sprint(v)))
+		}
+	}
+	return kvs
+}
@@ -0,0 +1,75 @@
+package modbusotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	modbusorm "github.com/TwoMental/modbus-orm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newRecordedTracer() (*Tracer, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return NewTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))), recorder
+}
+
+func TestTracer(t *testing.T) {
+	tracer, recorder := newRecordedTracer()
+	ctx, call := tracer.Start(context.Background(), "modbus.GetValue", modbusorm.Attribute{Key: "modbus.points", Value: []string{"power"}})
+	_, request := tracer.Start(ctx, "modbus.request",
+		modbusorm.Attribute{Key: "modbus.target", Value: "tcp://127.0.0.1:502/1"},
+		modbusorm.Attribute{Key: "modbus.address.start", Value: 10},
+		modbusorm.Attribute{Key: "modbus.plan.cached", Value: true},
+		modbusorm.Attribute{Key: "modbus.other", Value: uint16(7)},
+	)
+	request.SetAttributes(modbusorm.Attribute{Key: "modbus.attempt", Value: 1})
+	request.End(nil)
+	call.End(nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d, want 2", len(spans))
+	}
+	req, get := spans[0], spans[1]
+	if req.Name() != "modbus.request" || req.SpanKind() != trace.SpanKindClient || get.SpanKind() != trace.SpanKindInternal {
+		t.Fatalf("spans %s %v and %s %v, want the request a client span", req.Name(), req.SpanKind(), get.Name(), get.SpanKind())
+	}
+	if req.Parent().SpanID() != get.SpanContext().SpanID() {
+		t.Fatal("request span not in the call span")
+	}
+	want := []attribute.KeyValue{
+		attribute.String("modbus.target", "tcp://127.0.0.1:502/1"),
+		attribute.Int("modbus.address.start", 10),
+		attribute.Bool("modbus.plan.cached", true),
+		attribute.String("modbus.other", "7"),
+		attribute.Int("modbus.attempt", 1),
+	}
+	if got, wantSet := attribute.NewSet(req.Attributes()...), attribute.NewSet(want...); !got.Equals(&wantSet) {
+		t.Fatalf("request attributes = %v, want %v", got.ToSlice(), want)
+	}
+	if got := get.Attributes(); len(got) != 1 || got[0] != attribute.StringSlice("modbus.points", []string{"power"}) {
+		t.Fatalf("call attributes = %v", got)
+	}
+	if req.Status().Code != codes.Unset || len(req.Events()) != 0 {
+		t.Fatalf("status = %v with events %v, want unset", req.Status(), req.Events())
+	}
+}
+
+func TestTracerError(t *testing.T) {
+	tracer, recorder := newRecordedTracer()
+	_, span := tracer.Start(context.Background(), "modbus.request")
+	span.End(errors.New("i/o timeout"))
+
+	s := recorder.Ended()[0]
+	if s.Status().Code != codes.Error || s.Status().Description != "i/o timeout" {
+		t.Fatalf("status = %+v, want the error", s.Status())
+	}
+	if events := s.Events(); len(events) != 1 || events[0].Name != "exception" {
+		t.Fatalf("events = %v, want the error recorded", events)
+	}
+}
@@ -0,0 +1,208 @@
+// Package modbusprom expose the metrics of modbus-orm as Prometheus collectors
+/*
+	c := modbusprom.NewCollector("modbus")
+	prometheus.MustRegister(c)
+	m := modbusorm.NewModbusTCP(host, port, point, modbusorm.WithMetrics(c))
+*/
+package modbusprom
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	modbusorm "github.com/TwoMental/modbus-orm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements modbusorm.Metrics and prometheus.Collector
+type Collector struct {
+	requests        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	exceptions      *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	sentBytes       *prometheus.CounterVec
+	receivedBytes   *prometheus.CounterVec
+	registersRead   *prometheus.CounterVec
+	registersNeeded *prometheus.CounterVec
+
+	poolMaxOpen           *prometheus.Desc
+	poolOpen              *prometheus.Desc
+	poolInUse             *prometheus.Desc
+	poolIdle              *prometheus.Desc
+	poolWaitCount         *prometheus.Desc
+	poolWaitDuration      *prometheus.Desc
+	poolMaxIdleClosed     *prometheus.Desc
+	poolMaxIdleTimeClosed *prometheus.Desc
+	poolMaxLifetimeClosed *prometheus.Desc
+
+	mutex sync.RWMutex
+	pools map[string]func() modbusorm.PoolStats
+}
+
+var _ modbusorm.Metrics = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector allocates a Collector, namespace is the prefix of the metric names, like modbus
+/*
+	The request metrics are labeled with target and function_code, the pool metrics with transport.
+*/
+func NewCollector(namespace string) *Collector {
+	requestLabels := []string{"target", "function_code"}
+	poolDesc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "pool", name), help, []string{"transport"}, nil)
+	}
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "The number of request attempts, by result: ok, exception, error or rejected.",
+		}, append(requestLabels, "result")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "The duration of the request attempts.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, requestLabels),
+		exceptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "exceptions_total",
+			Help:      "The number of exception responses, by exception code.",
+		}, append(requestLabels, "exception_code")),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "The number of request attempts sent again by the retry policy.",
+		}, requestLabels),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sent_bytes_total",
+			Help:      "The bytes of the request PDUs sent.",
+		}, []string{"target"}),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "received_bytes_total",
+			Help:      "The bytes of the response PDUs received.",
+		}, []string{"target"}),
+		registersRead: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "block_registers_read_total",
+			Help:      "The registers read by the block reads.",
+		}, []string{"target"}),
+		registersNeeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "block_registers_needed_total",
+			Help:      "The registers of the points in the block reads, the gaps read are not counted.",
+		}, []string{"target"}),
+
+		poolMaxOpen:           poolDesc("max_open_connections", "The max number of open connections."),
+		poolOpen:              poolDesc("open_connections", "The number of established connections both in use and idle."),
+		poolInUse:             poolDesc("in_use_connections", "The number of connections in use."),
+		poolIdle:              poolDesc("idle_connections", "The number of idle connections."),
+		poolWaitCount:         poolDesc("wait_count_total", "The total number of connections waited for."),
+		poolWaitDuration:      poolDesc("wait_duration_seconds_total", "The total time blocked waiting for a connection."),
+		poolMaxIdleClosed:     poolDesc("max_idle_closed_total", "The total number of connections closed due to max idle connections."),
+		poolMaxIdleTimeClosed: poolDesc("max_idle_time_closed_total", "The total number of connections closed due to max idle time."),
+		poolMaxLifetimeClosed: poolDesc("max_lifetime_closed_total", "The total number of connections closed due to max lifetime."),
+
+		pools: make(map[string]func() modbusorm.PoolStats),
+	}
+}
+
+// ObserveRequest implements modbusorm.Metrics
+func (c *Collector) ObserveRequest(target string, obs modbusorm.RequestObservation) {
+	functionCode := strconv.Itoa(int(obs.Request.FunctionCode))
+	result := "ok"
+	if errors.Is(obs.Err, modbusorm.ErrRequestRejected) {
+		result = "rejected"
+	} else if obs.Err != nil {
+		result = "error"
+		if code, ok := modbusorm.ExceptionCode(obs.Err); ok {
+			result = "exception"
+			c.exceptions.WithLabelValues(target, functionCode, strconv.Itoa(int(code))).Inc()
+		}
+	}
+	c.requests.WithLabelValues(target, functionCode, result).Inc()
+	c.duration.WithLabelValues(target, functionCode).Observe(obs.Duration.Seconds())
+	if obs.Request.Attempt > 1 {
+		c.retries.WithLabelValues(target, functionCode).Inc()
+	}
+	c.sentBytes.WithLabelValues(target).Add(float64(obs.BytesSent))
+	c.receivedBytes.WithLabelValues(target).Add(float64(obs.BytesReceived))
+}
+
+// ObserveBlocks implements modbusorm.Metrics
+func (c *Collector) ObserveBlocks(target string, registersRead, registersNeeded int) {
+	c.registersRead.WithLabelValues(target).Add(float64(registersRead))
+	c.registersNeeded.WithLabelValues(target).Add(float64(registersNeeded))
+}
+
+// AddPool implements modbusorm.Metrics
+func (c *Collector) AddPool(transport string, stats func() modbusorm.PoolStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.pools[transport] = stats
+}
+
+// RemovePool implements modbusorm.Metrics
+func (c *Collector) RemovePool(transport string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.pools, transport)
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, vec := range c.vecs() {
+		vec.Describe(ch)
+	}
+	for _, desc := range c.poolDescs() {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector, the pool statistics are read on each scrape
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, vec := range c.vecs() {
+		vec.Collect(ch)
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for transport, stats := range c.pools {
+		s := stats()
+		gauge := func(desc *prometheus.Desc, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, transport)
+		}
+		counter := func(desc *prometheus.Desc, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, transport)
+		}
+		gauge(c.poolMaxOpen, float64(s.MaxOpenConnections))
+		gauge(c.poolOpen, float64(s.OpenConnections))
+		gauge(c.poolInUse, float64(s.InUse))
+		gauge(c.poolIdle, float64(s.Idle))
+		counter(c.poolWaitCount, float64(s.WaitCount))
+		counter(c.poolWaitDuration, s.WaitDuration.Seconds())
+		counter(c.poolMaxIdleClosed, float64(s.MaxIdleClosed))
+		counter(c.poolMaxIdleTimeClosed, float64(s.MaxIdleTimeClosed))
+		counter(c.poolMaxLifetimeClosed, float64(s.MaxLifetimeClosed))
+	}
+}
+
+func (c *Collector) vecs() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.requests, c.duration, c.exceptions, c.retries,
+		c.sentBytes, c.receivedBytes, c.registersRead, c.registersNeeded,
+	}
+}
+
+func (c *Collector) poolDescs() []*prometheus.Desc {
+	return []*prometheus.Desc{
+		c.poolMaxOpen, c.poolOpen, c.poolInUse, c.poolIdle,
+		c.poolWaitCount, c.poolWaitDuration,
+		c.poolMaxIdleClosed, c.poolMaxIdleTimeClosed, c.poolMaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,109 @@
+package modbusprom
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	modbusorm "github.com/TwoMental/modbus-orm"
+	"github.com/goburrow/modbus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const target = "tcp://127.0.0.1:502/1"
+
+func TestObserveRequest(t *testing.T) {
+	c := NewCollector("modbus")
+	read := modbusorm.Request{FunctionCode: modbus.FuncCodeReadHoldingRegisters, Address: 10, Quantity: 2, Attempt: 1}
+	exception := fmt.Errorf("read failed: %w", &modbus.ModbusError{FunctionCode: read.FunctionCode, ExceptionCode: modbus.ExceptionCodeServerDeviceBusy})
+	retry := read
+	retry.Attempt = 2
+
+	c.ObserveRequest(target, modbusorm.RequestObservation{Request: read, Duration: time.Millisecond, BytesSent: 5, BytesReceived: 6})
+	c.ObserveRequest(target, modbusorm.RequestObservation{Request: read, Duration: time.Millisecond, BytesSent: 5, BytesReceived: 2, Err: exception})
+	c.ObserveRequest(target, modbusorm.RequestObservation{Request: retry, Duration: time.Second, BytesSent: 5, Err: io.EOF})
+	c.ObserveRequest(target, modbusorm.RequestObservation{Request: read, Err: fmt.Errorf("%w: read only", modbusorm.ErrRequestRejected)})
+
+	for result, want := range map[string]float64{"ok": 1, "exception": 1, "error": 1, "rejected": 1} {
+		if got := testutil.ToFloat64(c.requests.WithLabelValues(target, "3", result)); got != want {
+			t.Errorf("requests_total{result=%q} = %v, want %v", result, got, want)
+		}
+	}
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"exceptions_total", testutil.ToFloat64(c.exceptions.WithLabelValues(target, "3", "6")), 1},
+		{"retries_total", testutil.ToFloat64(c.retries.WithLabelValues(target, "3")), 1},
+		{"sent_bytes_total", testutil.ToFloat64(c.sentBytes.WithLabelValues(target)), 15},
+		{"received_bytes_total", testutil.ToFloat64(c.receivedBytes.WithLabelValues(target)), 8},
+		{"request_duration_seconds series", float64(testutil.CollectAndCount(c.duration)), 1},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestObserveBlocks(t *testing.T) {
+	c := NewCollector("modbus")
+	c.ObserveBlocks(target, 10, 8)
+	c.ObserveBlocks(target, 4, 4)
+
+	if got := testutil.ToFloat64(c.registersRead.WithLabelValues(target)); got != 14 {
+		t.Errorf("block_registers_read_total = %v, want 14", got)
+	}
+	if got := testutil.ToFloat64(c.registersNeeded.WithLabelValues(target)); got != 12 {
+		t.Errorf("block_registers_needed_total = %v, want 12", got)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	c := NewCollector("modbus")
+	const transport = "tcp://127.0.0.1:502"
+	c.AddPool(transport, func() modbusorm.PoolStats {
+		return modbusorm.PoolStats{MaxOpenConnections: 5, OpenConnections: 3, InUse: 2, Idle: 1, WaitCount: 4, WaitDuration: 1500 * time.Millisecond}
+	})
+
+	want := `
+# HELP modbus_pool_in_use_connections The number of connections in use.
+# TYPE modbus_pool_in_use_connections gauge
+modbus_pool_in_use_connections{transport="tcp://127.0.0.1:502"} 2
+# HELP modbus_pool_open_connections The number of established connections both in use and idle.
+# TYPE modbus_pool_open_connections gauge
+modbus_pool_open_connections{transport="tcp://127.0.0.1:502"} 3
+# HELP modbus_pool_wait_duration_seconds_total The total time blocked waiting for a connection.
+# TYPE modbus_pool_wait_duration_seconds_total counter
+modbus_pool_wait_duration_seconds_total{transport="tcp://127.0.0.1:502"} 1.5
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "modbus_pool_in_use_connections", "modbus_pool_open_connections", "modbus_pool_wait_duration_seconds_total"); err != nil {
+		t.Fatal(err)
+	}
+	// The pool statistics are read on each scrape
+	if n := testutil.CollectAndCount(c); n != 9 {
+		t.Fatalf("metrics collected = %d, want the 9 of the pool", n)
+	}
+
+	c.RemovePool(transport)
+	if n := testutil.CollectAndCount(c); n != 0 {
+		t.Fatalf("metrics collected after RemovePool = %d, want 0", n)
+	}
+}
+
+func TestLint(t *testing.T) {
+	c := NewCollector("modbus")
+	c.ObserveRequest(target, modbusorm.RequestObservation{Request: modbusorm.Request{FunctionCode: modbus.FuncCodeReadHoldingRegisters, Attempt: 2}, Err: &modbus.ModbusError{ExceptionCode: 2}})
+	c.ObserveBlocks(target, 1, 1)
+	c.AddPool("tcp://127.0.0.1:502", func() modbusorm.PoolStats { return modbusorm.PoolStats{} })
+
+	problems, err := testutil.CollectAndLint(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("%s: %s", p.Metric, p.Text)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math"
 	"reflect"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/goburrow/modbus"
@@ -18,8 +19,11 @@ import (
 type ConnType uint8
 
 const (
-	ConnTypeTCP ConnType = 1
-	ConnTypeRTU ConnType = 2
+	ConnTypeTCP        ConnType = 1
+	ConnTypeRTU        ConnType = 2
+	ConnTypeRTUOverTCP ConnType = 3
+	ConnTypeASCII      ConnType = 4
+	ConnTypeUDP        ConnType = 5
 )
 
 type Modbus struct {
@@ -35,8 +39,39 @@ type Modbus struct {
 	withBlock     bool
 	maxBlockSize  uint16
 	maxGapInBlock uint16
+	forbidden     []AddrRange
+	holeTTL       time.Duration
+	costModel     CostModel
+	measureCost   bool
+	costs         costEstimator
+	plans         planCache
+
+	readConcurrency int
+	requestDelay    time.Duration
+
+	partialResults bool
+	staleAfter     time.Duration
+
+	cacheTTL   time.Duration
+	strictEnum bool
+
+	retryPolicy   RetryPolicy
+	retryCounters retryCounters
+	breakerConfig *BreakerConfig
+	breaker       *breaker
+
+	interceptors []Interceptor
+
+	metrics Metrics
+	tracer  Tracer
+
+	logger Logger // set by WithLogger
+	log    Logger // logger with the target, set by Conn
+
+	role string // Modbus/TCP Security role of the client certificate
 
 	connPool ConnPool
+	shared   *Modbus // the modbus owning the transport, for devices sharing it
 }
 
 func newDefaultModbus() *Modbus {
@@ -47,6 +82,15 @@ func newDefaultModbus() *Modbus {
 		withBlock:     false,
 		maxBlockSize:  100,
 		maxGapInBlock: 10,
+		costModel:     defaultCostModel,
+
+		readConcurrency: 1,
+		requestDelay:    1 * time.Millisecond,
+
+		metrics: nopMetrics{},
+		tracer:  nopTracer{},
+		logger:  nopLogger{},
+		log:     nopLogger{},
 	}
 }
 
@@ -85,16 +129,101 @@ func NewModbusRTU(comAddr string, point Point, opts ...ModbusOption) *Modbus {
 	return m
 }
 
+// NewModbusRTUOverTCP RTU frames over TCP, like serial-to-Ethernet converters
+/*
+	The converter forwards one request at a time to the serial line, so the max open connections is 1 by default.
+*/
+func NewModbusRTUOverTCP(host string, port int, point Point, opts ...ModbusOption) *Modbus {
+	m := newDefaultModbus()
+	m.connType = ConnTypeRTUOverTCP
+	m.modbusTCP = modbusTCP{
+		Host:            host,
+		Port:            port,
+		MaxOpenConns:    1,
+		ConnMaxLifetime: 30 * time.Minute,
+	}
+	m.points = point
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewModbusUDP Modbus over UDP, requests are sent again on timeout
+func NewModbusUDP(host string, port int, point Point, opts ...ModbusOption) *Modbus {
+	m := newDefaultModbus()
+	m.connType = ConnTypeUDP
+	m.modbusTCP = modbusTCP{
+		Host:            host,
+		Port:            port,
+		MaxOpenConns:    3,
+		ConnMaxLifetime: 30 * time.Minute,
+		Retransmits:     2,
+	}
+	m.points = point
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// NewModbusASCII Modbus ASCII over a serial port, 7 data bits and even parity by default
+func NewModbusASCII(comAddr string, point Point, opts ...ModbusOption) *Modbus {
+	m := newDefaultModbus()
+	m.connType = ConnTypeASCII
+	m.modbusRTU = modbusRTU{
+		ComAddr:  comAddr,
+		BaudRate: 9600,
+		DataBits: 7,
+		Parity:   "E",
+		StopBits: 1,
+	}
+	m.points = point
+
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
 func (m *Modbus) Conn() error {
-	if m.connType == ConnTypeTCP {
-		return m.connTCP()
-	} else if m.connType == ConnTypeRTU {
-		return m.connRTU()
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	m.log = withFields(m.logger, "target", m.target())
+	if m.breakerConfig != nil {
+		m.breaker = newBreaker(*m.breakerConfig, m.target(), m.log)
 	}
+	if m.shared != nil {
+		// the transport is opened by the modbus owning it
+		return nil
+	}
+	var err error
+	switch m.connType {
+	case ConnTypeTCP:
+		err = m.connTCP()
+	case ConnTypeRTU:
+		err = m.connRTU()
+	case ConnTypeRTUOverTCP:
+		err = m.connRTUOverTCP()
+	case ConnTypeASCII:
+		err = m.connASCII()
+	case ConnTypeUDP:
+		err = m.connUDP()
+	}
+	if err != nil || m.connPool == nil {
+		return err
+	}
+	m.metrics.AddPool(m.transport(), m.connPool.Stats)
 	return nil
 }
 
 func (m *Modbus) connTCP() error {
+	if m.TLSConfig != nil || m.TLSCertFile != "" || m.TLSKeyFile != "" || m.TLSCAFile != "" {
+		return m.connTLS()
+	}
 	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
 	factory := func() (Client, error) {
 		handler := modbus.NewTCPClientHandler(addr)
@@ -106,9 +235,87 @@ func (m *Modbus) connTCP() error {
 		client := modbus.NewClient(handler)
 		return &ModbusTCPClient{Client: client, Handler: handler, createTime: time.Now()}, nil
 	}
+	return m.newTCPPool(factory)
+}
+
+func (m *Modbus) connTLS() error {
+	config := m.TLSConfig
+	if config == nil {
+		var err error
+		if config, err = loadTLSConfig(m.TLSCertFile, m.TLSKeyFile, m.TLSCAFile); err != nil {
+			return err
+		}
+	}
+	role, err := certificateRole(config)
+	if err != nil {
+		return err
+	}
+	m.role = role
+
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	factory := func() (Client, error) {
+		handler := NewTLSClientHandler(addr, config)
+		handler.Timeout = m.timeout
+		handler.HandshakeTimeout = m.HandshakeTimeout
+		handler.SlaveId = m.slaveID
+		if e := handler.Connect(); e != nil {
+			return nil, e
+		}
+		return NewModbusHandlerClient(handler, &handler.SlaveId), nil
+	}
+	return m.newTCPPool(factory)
+}
+
+// Role Get the Modbus/TCP Security role of the client certificate, empty if it has no role
+func (m *Modbus) Role() string {
+	if m.shared != nil {
+		return m.shared.role
+	}
+	return m.role
+}
+
+func (m *Modbus) connRTUOverTCP() error {
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	factory := func() (Client, error) {
+		handler := NewRTUOverTCPClientHandler(addr)
+		handler.Timeout = m.timeout
+		handler.SlaveId = m.slaveID
+		if e := handler.Connect(); e != nil {
+			return nil, e
+		}
+		return NewModbusHandlerClient(handler, &handler.SlaveId), nil
+	}
+	return m.newTCPPool(factory)
+}
+
+func (m *Modbus) connUDP() error {
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	factory := func() (Client, error) {
+		handler := NewUDPClientHandler(addr)
+		handler.Timeout = m.timeout
+		handler.SlaveId = m.slaveID
+		handler.Retransmits = m.Retransmits
+		if e := handler.Connect(); e != nil {
+			return nil, e
+		}
+		return NewModbusHandlerClient(handler, &handler.SlaveId), nil
+	}
+	return m.newTCPPool(factory)
+}
+
+// newTCPPool create the pool of the connections made by factory
+func (m *Modbus) newTCPPool(factory func() (Client, error)) error {
 	config := ModbusTCPPoolConfig{
-		MaxOpenConns:    m.MaxOpenConns,
-		ConnMaxLifetime: m.ConnMaxLifetime,
+		MaxOpenConns:        m.MaxOpenConns,
+		ConnMaxLifetime:     m.ConnMaxLifetime,
+		MaxIdleConns:        m.MaxIdleConns,
+		ConnMaxIdleTime:     m.ConnMaxIdleTime,
+		Lazy:                m.Lazy,
+		ReconnectMinBackoff: m.ReconnectMinBackoff,
+		ReconnectMaxBackoff: m.ReconnectMaxBackoff,
+		HealthCheckInterval: m.HealthCheckInterval,
+		HealthCheckProbe:    m.HealthCheckProbe,
+		Logger:              m.log,
 	}
 
 	pool, err := NewModbusTCPPool(config, factory)
@@ -132,38 +339,203 @@ func (m *Modbus) connRTU() error {
 	}
 	client := modbus.NewClient(handler)
 
-	pool, err := NewModbusRTUPool(&ModbusRTUClient{Client: client, Handler: handler, createTime: time.Now()})
+	frameDelay := m.FrameDelay
+	if frameDelay == 0 {
+		frameDelay = silentInterval(m.BaudRate, m.DataBits, m.Parity, m.StopBits)
+	}
+	return m.newSerialPool(&ModbusRTUClient{Client: client, Handler: handler, createTime: time.Now()}, frameDelay)
+}
+
+func (m *Modbus) connASCII() error {
+	handler := modbus.NewASCIIClientHandler(m.ComAddr)
+	handler.BaudRate = m.BaudRate
+	handler.DataBits = m.DataBits
+	handler.Parity = m.Parity
+	handler.StopBits = m.StopBits
+	handler.SlaveId = m.slaveID
+	handler.Timeout = m.timeout
+	if e := handler.Connect(); e != nil {
+		return e
+	}
+	// ASCII frames are delimited by CR LF, no silent interval is needed
+	return m.newSerialPool(NewModbusHandlerClient(handler, &handler.SlaveId), m.FrameDelay)
+}
+
+// newSerialPool create the pool of the serial bus
+func (m *Modbus) newSerialPool(client Client, frameDelay time.Duration) error {
+	config := ModbusRTUPoolConfig{
+		FrameDelay: frameDelay,
+		Logger:     m.log,
+	}
+
+	pool, err := NewModbusRTUPool(client, config)
 	if err != nil {
 		return fmt.Errorf("failed to create RTU pool: %w", err)
 	}
 	m.connPool = pool
 	return nil
+}
+
+// Validate Check the points, no readable point is allowed inside the forbidden ranges
+func (m *Modbus) Validate() error {
+	forbidden := m.forbiddenRanges()
+	for name, p := range m.points {
+		if p.Forbidden {
+			continue
+		}
+		r := p.addrRange()
+		for _, f := range forbidden {
+			if f.overlaps(r.Start, r.End) {
+				return fmt.Errorf("point %s (%d-%d) is inside forbidden range %d-%d", name, r.Start, r.End, f.Start, f.End)
+			}
+		}
+	}
+	return nil
+}
+
+// target the connection target of the modbus, including the slave id
+func (m *Modbus) target() string {
+	switch m.connType {
+	case ConnTypeTCP:
+		return fmt.Sprintf("tcp://%s:%d/%d", m.Host, m.Port, m.slaveID)
+	case ConnTypeRTU:
+		return fmt.Sprintf("rtu://%s/%d", m.ComAddr, m.slaveID)
+	case ConnTypeRTUOverTCP:
+		return fmt.Sprintf("rtu+tcp://%s:%d/%d", m.Host, m.Port, m.slaveID)
+	case ConnTypeASCII:
+		return fmt.Sprintf("ascii://%s/%d", m.ComAddr, m.slaveID)
+	case ConnTypeUDP:
+		return fmt.Sprintf("udp://%s:%d/%d", m.Host, m.Port, m.slaveID)
+	}
+	return ""
+}
+
+// Device Get a logical device sharing the transport of m, with its own slave id and point table
+/*
+	Many devices can be reached through one serial port or TCP gateway,
+	the slave id is set per request, and the devices take turns to use the transport.
+	The device does not inherit the options of m, pass them in opts, except those of the transport.
+	Conn and Close of the device do not open or close the transport, m does.
+*/
+func (m *Modbus) Device(slaveID uint8, point Point, opts ...ModbusOption) *Modbus {
+	owner := m
+	if m.shared != nil {
+		owner = m.shared
+	}
+	d := newDefaultModbus()
+	d.connType = owner.connType
+	d.modbusTCP = owner.modbusTCP
+	d.modbusRTU = owner.modbusRTU
+	d.timeout = owner.timeout
+	d.shared = owner
+	d.slaveID = slaveID
+	d.points = point
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// pool get the connection pool of the transport
+func (m *Modbus) pool() ConnPool {
+	if m.shared != nil {
+		return m.shared.connPool
+	}
+	return m.connPool
+}
+
+// getConn check out a connection, and set the slave id for the requests on it
+func (m *Modbus) getConn(ctx context.Context) (_ Client, err error) {
+	ctx, span := m.startSpan(ctx, "modbus.acquire")
+	defer func() { span.End(err) }()
+
+	conn, err := m.pool().Get(withDevice(ctx, m.slaveID))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetSlaveID(m.slaveID)
+	return conn, nil
+}
 
+// putConn give back the connection
+func (m *Modbus) putConn(conn Client) error {
+	return m.pool().Put(conn)
 }
 
 func (m *Modbus) Close() error {
+	if m.shared != nil {
+		return nil
+	}
+	m.metrics.RemovePool(m.transport())
 	return m.connPool.Close()
 }
 
+// State Get the state of the connection to the device
+func (m *Modbus) State() ConnState {
+	if w, ok := m.pool().(stateWatcher); ok {
+		return w.State()
+	}
+	if m.pool() == nil {
+		return ConnStateDown
+	}
+	return ConnStateUp
+}
+
+// WatchState Watch the state of the connection to the device
+/*
+	The current state is sent first, slow watchers only receive the latest state.
+	The channel is closed when ctx is done.
+*/
+func (m *Modbus) WatchState(ctx context.Context) <-chan ConnState {
+	if w, ok := m.pool().(stateWatcher); ok {
+		return w.WatchState(ctx)
+	}
+	ch := make(chan ConnState, 1)
+	ch <- m.State()
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+// Stats Get the statistics of the connection pool
+func (m *Modbus) Stats() PoolStats {
+	return m.pool().Stats()
+}
+
 // GetValue Get value from modbus and write to v.
-func (m *Modbus) GetValue(ctx context.Context, point string, v any) error {
+func (m *Modbus) GetValue(ctx context.Context, point string, v any) (err error) {
+	ctx, span := m.startSpan(ctx, "modbus.GetValue", Attribute{Key: "modbus.points", Value: []string{point}})
+	defer func() { span.End(err) }()
+
 	fieldDetail, ok := m.points[point]
 	if !ok {
 		return fmt.Errorf("point for %s not found", point)
 	}
-	conn, err := m.connPool.Get()
-	if err != nil {
-		return fmt.Errorf("conn slave failed: %w", err)
+	if fieldDetail.Forbidden {
+		return fmt.Errorf("point %s is forbidden to read", point)
 	}
-	defer m.connPool.Put(conn)
+	data, ok := m.cacheGet(fieldDetail)
+	if !ok {
+		sess, err := m.newSession(ctx)
+		if err != nil {
+			return err
+		}
+		defer sess.close()
 
-	data, err := conn.ReadHoldingRegisters(fieldDetail.Addr, fieldDetail.Quantity)
-	if err != nil {
-		return fmt.Errorf("ReadHoldingRegisters for %s failed, %w", point, err)
+		generation := m.cacheGeneration()
+		data, err = m.readHoldingRegisters(sess, fieldDetail.Addr, fieldDetail.Quantity, []string{point})
+		if err != nil {
+			return fmt.Errorf("ReadHoldingRegisters for %s failed, %w", point, err)
+		}
+		m.cachePut(fieldDetail.Addr, data, time.Now(), generation)
 	}
 
 	dataFloat64Before, err := parseDataToFloat64(data, fieldDetail.DataType, fieldDetail.OrderType)
 	if err != nil {
+		m.log.Warn("modbus decode failed", "point", point, "error", err)
 		return err
 	}
 	dataFloat64 := cal(dataFloat64Before, fieldDetail.GetCoefficient()) + fieldDetail.Offset
@@ -193,7 +565,11 @@ func (m *Modbus) GetValue(ctx context.Context, point string, v any) error {
 	case *uint32:
 		*v = uint32(dataFloat64)
 	case *string:
-		*v = byte2String(data)
+		if len(fieldDetail.Enum) > 0 {
+			*v, parseErr = m.enumName(fieldDetail, int(dataFloat64))
+		} else {
+			*v = byte2String(data)
+		}
 	case *[]int, *[]int8, *[]int16, *[]int32, *[]int64, *[]uint, *[]uint8, *[]uint16, *[]uint32, *[]uint64:
 		elemType := reflect.TypeOf(v).Elem().Elem()
 		elemSize := elemType.Size()
@@ -231,25 +607,48 @@ func (m *Modbus) GetValue(ctx context.Context, point string, v any) error {
 type block struct {
 	start   uint16
 	end     uint16
+	ranges  []AddrRange // requested ranges in the block
 	vaulues []byte
+	readAt  time.Time // the time the values were read
+	err     error
 }
 
 type blocks map[uint16]*block
 
-func (m *Modbus) GetValuesBlock(ctx context.Context, v any, filter ...string) error {
-	// Get the address blocks
-	addrMap := make(map[uint16]struct{})
+// readAt the time the block of the address was read
+func (bs blocks) readAt(addr uint16) time.Time {
+	for _, b := range bs {
+		if b.start <= addr && addr <= b.end {
+			return b.readAt
+		}
+	}
+	return time.Time{}
+}
+
+func (m *Modbus) GetValuesBlock(ctx context.Context, v any, filter ...string) (err error) {
+	ctx, span := m.startSpan(ctx, "modbus.GetValues", Attribute{Key: "modbus.mode", Value: "block"})
+	defer func() { span.End(err) }()
+
 	filterMap := parseFilter(filter)
-	m.collectAddresses(ctx, v, addrMap, filterMap)
-	if len(addrMap) == 0 {
-		return fmt.Errorf("no address found")
+	begin := time.Now()
+	defer func() {
+		if err != nil {
+			m.failSamples(v, filterMap, begin, err)
+		}
+	}()
+
+	if m.cacheTTL > 0 {
+		return m.getValuesCached(ctx, v, filterMap)
 	}
 
-	// Convert the map to block list
-	bs := m.addrMapToBlocks(ctx, addrMap)
+	// Plan the address blocks
+	bs, err := m.planValues(ctx, v, filter, filterMap)
+	if err != nil {
+		return err
+	}
 
 	// Read the blocks
-	err := m.readBlocks(ctx, bs)
+	err = m.readBlocks(ctx, bs)
 	if err != nil {
 		return err
 	}
@@ -258,48 +657,64 @@ func (m *Modbus) GetValuesBlock(ctx context.Context, v any, filter ...string) er
 	return m.setAddressValues(ctx, v, bs, filterMap)
 }
 
-func (m *Modbus) addrMapToBlocks(_ context.Context, addrMap map[uint16]struct{}) blocks {
-	// Convert the map to a slice of addresses
-	addrs := make([]uint16, 0, len(addrMap))
-	for addr := range addrMap {
-		addrs = append(addrs, addr)
-	}
+// planValues plan the blocks to read for v, the plans are cached per struct type and filter set
+func (m *Modbus) planValues(ctx context.Context, v any, filter []string, filterMap map[string]bool) (blocks, error) {
+	key := planKey{typ: reflect.TypeOf(v), filter: filterKey(filter)}
+	return m.planRanges(ctx, key, key.typ.String(), func() ([]AddrRange, error) {
+		return m.collectRanges(ctx, v, nil, filterMap)
+	})
+}
 
-	// Sort the addresses
-	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+// planRanges plan the blocks to read for the ranges collected, the plan is cached by key
+func (m *Modbus) planRanges(ctx context.Context, key planKey, name string, collect func() ([]AddrRange, error)) (_ blocks, err error) {
+	_, span := m.startSpan(ctx, "modbus.plan")
+	defer func() { span.End(err) }()
 
-	// Group continuous addresses into blocks, merge blocks with small gaps
-	bs := make(blocks)
-	start := addrs[0]
-	end := addrs[0]
-	for i := 1; i < len(addrs); i++ {
-		if addrs[i] == end+1 {
-			end = addrs[i]
-		} else if addrs[i]-end <= m.maxGapInBlock && end-start+1+addrs[i]-end <= m.maxBlockSize {
-			end = addrs[i]
-		} else {
-			bs[start] = &block{start: start, end: end}
-			start = addrs[i]
-			end = addrs[i]
+	target := m.target()
+	version := holesVersion(target, m.holeTTL)
+	cost := m.currentCostModel()
+
+	planned, ok := m.plans.get(key, version, cost)
+	span.SetAttributes(Attribute{Key: "modbus.plan.cached", Value: ok})
+	if !ok {
+		ranges, err := collect()
+		if err != nil {
+			return nil, err
+		}
+		if len(ranges) == 0 {
+			return nil, fmt.Errorf("no address found")
 		}
+		planned = planBlocks(ranges, planConfig{
+			maxQuantity: m.maxBlockQuantity(),
+			maxGap:      m.maxGapInBlock,
+			unreadable:  m.unreadableRanges(),
+			cost:        cost,
+		})
+		m.plans.put(key, version, cost, planned)
+		m.log.Debug("modbus blocks planned", "blocks", formatBlocks(planned), "type", name)
 	}
-	bs[start] = &block{start: start, end: end}
 
-	return bs
+	bs := make(blocks, len(planned))
+	for _, b := range planned {
+		bs[b.start] = b
+	}
+	span.SetAttributes(Attribute{Key: "modbus.blocks", Value: len(bs)})
+	return bs, nil
 }
 
-func (m *Modbus) collectAddresses(ctx context.Context, v any, addrMap map[uint16]struct{}, filterMap map[string]bool) error {
+// collectRanges collect the address ranges of the points in v
+func (m *Modbus) collectRanges(ctx context.Context, v any, ranges []AddrRange, filterMap map[string]bool) ([]AddrRange, error) {
 	// validate v
 	val := reflect.ValueOf(v)
 	if val.Kind() != reflect.Ptr {
-		return fmt.Errorf("not support for %s", val.Kind().String())
+		return nil, fmt.Errorf("not support for %s", val.Kind().String())
 	}
 
 	valueElem := val.Elem()
 	typeElem := reflect.TypeOf(v).Elem()
 
 	if valueElem.Kind() != reflect.Struct {
-		return fmt.Errorf("not support for %s pointer", valueElem.Kind().String())
+		return nil, fmt.Errorf("not support for %s pointer", valueElem.Kind().String())
 	}
 
 	// filter
@@ -307,7 +722,7 @@ func (m *Modbus) collectAddresses(ctx context.Context, v any, addrMap map[uint16
 
 	for i := 0; i < valueElem.NumField(); i++ {
 		value := valueElem.Field(i)
-		if value.Kind() == reflect.Struct {
+		if value.Kind() == reflect.Struct && !isSampleType(value.Type()) {
 			// dive
 			if !value.CanAddr() {
 				continue
@@ -316,8 +731,9 @@ func (m *Modbus) collectAddresses(ctx context.Context, v any, addrMap map[uint16
 			if !addr.IsValid() || !addr.CanInterface() {
 				continue
 			}
-			if e := m.collectAddresses(ctx, addr.Interface(), addrMap, filterMap); e != nil {
-				return e
+			var err error
+			if ranges, err = m.collectRanges(ctx, addr.Interface(), ranges, filterMap); err != nil {
+				return nil, err
 			}
 			continue
 		}
@@ -329,43 +745,241 @@ func (m *Modbus) collectAddresses(ctx context.Context, v any, addrMap map[uint16
 			continue
 		}
 		fieldDetail, ok := m.points[fieldName]
-		if !ok {
+		if !ok || fieldDetail.Forbidden {
 			continue
 		}
-		var quantity uint16 = 1
-		if fieldDetail.Quantity != 0 {
-			quantity = fieldDetail.Quantity
+		ranges = append(ranges, fieldDetail.addrRange())
+	}
+	return ranges, nil
+}
+
+func (m *Modbus) readBlocks(ctx context.Context, bs blocks) error {
+	planned := make([]*block, 0, len(bs))
+	for _, b := range bs {
+		planned = append(planned, b)
+	}
+	sort.Slice(planned, func(i, j int) bool { return planned[i].start < planned[j].start })
+
+	// Read each block, the blocks rejected by the device may be split
+	results := make([]blockResult, len(planned))
+	if workers := m.readWorkers(len(planned)); workers > 1 {
+		m.readBlocksConcurrently(ctx, planned, results, workers)
+	} else {
+		m.readBlocksSequentially(ctx, planned, results)
+	}
+
+	// Report the error of the lowest address, so that errors are deterministic
+	for i, b := range planned {
+		if results[i].err != nil {
+			return results[i].err
 		}
-		var j uint16 = 0
-		for ; j < quantity; j++ {
-			addrMap[fieldDetail.Addr+j] = struct{}{}
+		delete(bs, b.start)
+		for _, r := range results[i].read {
+			bs[r.start] = r
 		}
-
 	}
+	m.observeBlocks(bs)
 	return nil
 }
 
-func (m *Modbus) readBlocks(_ context.Context, bs blocks) error {
-	// Get a connection
-	conn, err := m.connPool.Get()
+// blockResult the blocks actually read for a planned block
+type blockResult struct {
+	read []*block
+	err  error
+}
+
+// readWorkers the number of connections to read the blocks with
+func (m *Modbus) readWorkers(blockNum int) int {
+	// The serial line is a single bus, even behind a TCP converter, the blocks are always read sequentially
+	if m.connType != ConnTypeTCP && m.connType != ConnTypeUDP {
+		return 1
+	}
+	return min(m.readConcurrency, blockNum)
+}
+
+func (m *Modbus) readBlocksSequentially(ctx context.Context, planned []*block, results []blockResult) {
+	sess, err := m.newSession(ctx)
 	if err != nil {
-		return fmt.Errorf("conn slave failed: %w", err)
+		for i := range results {
+			results[i].err = err
+		}
+		return
 	}
-	defer m.connPool.Put(conn)
-	// Read each block
-	for _, b := range bs {
-		data, err := m.readHoldingRegisters(conn, uint16(b.start), uint16(b.end-b.start+1))
-		if err != nil {
-			return err
+	defer sess.close()
+
+	for i, b := range planned {
+		results[i].read, results[i].err = m.readBlock(sess, b)
+		if results[i].err != nil {
+			return
 		}
-		if len(data) != int(b.end-b.start+1)*2 {
-			return fmt.Errorf("read block failed, want %d, got %d", (b.end-b.start+1)*2, len(data))
+	}
+}
+
+// readBlocksConcurrently fan the blocks out across pooled connections
+func (m *Modbus) readBlocksConcurrently(ctx context.Context, planned []*block, results []blockResult, workers int) {
+	jobs := make(chan int, len(planned))
+	for i := range planned {
+		jobs <- i
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	connErrs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		w := w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := m.newSession(ctx)
+			if err != nil {
+				// Leave the blocks to the other workers
+				connErrs[w] = err
+				return
+			}
+			defer sess.close()
+			for i := range jobs {
+				results[i].read, results[i].err = m.readBlock(sess, planned[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	// No worker got a connection for the blocks left, report why each failed
+	var connErr error
+	for i := range jobs {
+		if connErr == nil {
+			connErr = joinErrors(connErrs...)
+		}
+		results[i].err = connErr
+	}
+}
+
+// readBlock read a block, return the blocks actually read
+/*
+	If the device rejects the block with an illegal data address exception,
+	the block will be bisected at the point boundaries down to single points,
+	and the gaps found unreadable will be remembered as holes of the target.
+*/
+func (m *Modbus) readBlock(sess *session, b *block) ([]*block, error) {
+	quantity := b.end - b.start + 1
+	begin := time.Now()
+	generation := m.cacheGeneration()
+	data, err := m.readHoldingRegisters(sess, b.start, quantity, m.pointsIn(b.start, b.end))
+	if err == nil && len(data) != int(quantity)*2 {
+		err = fmt.Errorf("read block failed, want %d, got %d", quantity*2, len(data))
+	}
+	if err == nil {
+		if m.measureCost && quantity <= m.maxQuantity && !sess.retried {
+			m.costs.observe(quantity, time.Since(begin))
 		}
 		b.vaulues = data
+		b.readAt = time.Now()
+		m.cachePut(b.start, data, b.readAt, generation)
 		// Avoid make server too busy
-		time.Sleep(1 * time.Millisecond)
+		if m.requestDelay > 0 {
+			time.Sleep(m.requestDelay)
+		}
+		return []*block{b}, nil
 	}
-	return nil
+	if isIllegalDataAddress(err) {
+		if left, right, ok := m.splitRanges(b); ok {
+			return m.splitBlock(sess, left, right)
+		}
+	}
+	if !m.partialResults {
+		return nil, err
+	}
+	// Keep going, the points in this block will report the error
+	b.err = err
+	return []*block{b}, nil
+}
+
+// pointsIn the names of the readable points overlapping [start, end], sorted
+func (m *Modbus) pointsIn(start, end uint16) []string {
+	var names []string
+	for name, p := range m.points {
+		if !p.Forbidden && p.addrRange().overlaps(start, end) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitRanges bisect the requested ranges of the block at a point boundary
+/*
+	A block of several ranges is split between them, a single range between its points,
+	so a point is never read in parts. ok is false if the block is a single point.
+*/
+func (m *Modbus) splitRanges(b *block) (left, right []AddrRange, ok bool) {
+	if len(b.ranges) > 1 {
+		half := len(b.ranges) / 2
+		return b.ranges[:half], b.ranges[half:], true
+	}
+	r := b.ranges[0]
+	mid := uint32(r.Start) + (uint32(r.End)-uint32(r.Start)+1)/2
+	var cut uint32
+	for addr := uint32(r.Start) + 1; addr <= uint32(r.End); addr++ {
+		if m.splitsPoint(uint16(addr)) {
+			continue
+		}
+		// The boundary closest to the middle
+		if cut == 0 || absDiff(addr, mid) < absDiff(cut, mid) {
+			cut = addr
+		}
+	}
+	if cut == 0 {
+		return nil, nil, false
+	}
+	return []AddrRange{{Start: r.Start, End: uint16(cut - 1)}}, []AddrRange{{Start: uint16(cut), End: r.End}}, true
+}
+
+// splitsPoint check if a split before addr would read a readable point in parts
+func (m *Modbus) splitsPoint(addr uint16) bool {
+	for _, p := range m.points {
+		if r := p.addrRange(); !p.Forbidden && r.Start < addr && addr <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// splitBlock read the halves of a block, the requested ranges of each
+func (m *Modbus) splitBlock(sess *session, leftRanges, rightRanges []AddrRange) ([]*block, error) {
+	left := &block{start: leftRanges[0].Start, end: leftRanges[len(leftRanges)-1].End, ranges: leftRanges}
+	right := &block{start: rightRanges[0].Start, end: rightRanges[len(rightRanges)-1].End, ranges: rightRanges}
+
+	leftRead, err := m.readBlock(sess, left)
+	if err != nil {
+		return nil, err
+	}
+	rightRead, err := m.readBlock(sess, right)
+	if err != nil {
+		return nil, err
+	}
+	// Both halves are readable, so the gap between them is what the device rejects
+	if right.start > left.end+1 && allRead(leftRead) && allRead(rightRead) {
+		hole := AddrRange{Start: left.end + 1, End: right.start - 1}
+		learnHole(m.target(), hole)
+		m.log.Info("modbus hole learned", "address", hole.Start, "quantity", hole.End-hole.Start+1)
+	}
+	return append(leftRead, rightRead...), nil
+}
+
+func allRead(bs []*block) bool {
+	for _, b := range bs {
+		if b.err != nil {
+			return false
+		}
+	}
+	return true
 }
 
 func (m *Modbus) setAddressValues(ctx context.Context, v any, values blocks, filterMap map[string]bool) error {
@@ -373,9 +987,11 @@ func (m *Modbus) setAddressValues(ctx context.Context, v any, values blocks, fil
 	valueElem := reflect.ValueOf(v).Elem()
 	typeElem := reflect.TypeOf(v).Elem()
 
+	errs := &ValuesError{}
 	for i := 0; i < valueElem.NumField(); i++ {
 		value := valueElem.Field(i)
-		if value.Kind() == reflect.Struct {
+		sample, sampleValue, isSample := asSample(value)
+		if value.Kind() == reflect.Struct && !isSample {
 			// dive
 			if !value.CanAddr() {
 				continue
@@ -385,7 +1001,9 @@ func (m *Modbus) setAddressValues(ctx context.Context, v any, values blocks, fil
 				continue
 			}
 			if e := m.setAddressValues(ctx, addr.Interface(), values, filterMap); e != nil {
-				return e
+				if !m.partialResults || !errs.merge(e) {
+					return e
+				}
 			}
 			continue
 		}
@@ -397,92 +1015,160 @@ func (m *Modbus) setAddressValues(ctx context.Context, v any, values blocks, fil
 			continue
 		}
 		fieldDetail, ok := m.points[fieldName]
-		if !ok {
+		if !ok || fieldDetail.Forbidden {
 			continue
 		}
 		var quantity uint16 = 1
 		if fieldDetail.Quantity != 0 {
 			quantity = fieldDetail.Quantity
 		}
+		if isSample {
+			value = sampleValue
+		}
 		// find data
-		data := m.getFieldData([]byte{}, values, fieldDetail.Addr, quantity)
-
-		// set value
-		dataFloat64Before, err := parseDataToFloat64(data, fieldDetail.DataType, fieldDetail.OrderType)
+		data, err := m.getFieldData([]byte{}, values, fieldDetail.Addr, quantity)
+		if err == nil {
+			// set value
+			if err = m.setFieldValue(value, fieldDetail, data); err != nil {
+				m.log.Warn("modbus decode failed", "point", fieldName, "error", err)
+			}
+		}
+		if sample != nil {
+			if err != nil {
+				sample.setFailed(err, m.staleAfter)
+			} else {
+				sample.setRead(values.readAt(fieldDetail.Addr))
+			}
+		}
 		if err != nil {
-			return err
+			if !m.partialResults {
+				return err
+			}
+			errs.add(fieldName, err)
+		}
+	}
+	return errs.errOrNil()
+}
+
+// getFieldData get the data of quantity registers from addr, which may span several blocks
+func (m *Modbus) getFieldData(data []byte, values blocks, addr uint16, quantity uint16) ([]byte, error) {
+	for start, block := range values {
+		if start <= addr && addr <= block.end {
+			if block.err != nil {
+				return nil, block.err
+			}
+			if addr+quantity-1 <= block.end {
+				data = append(data, block.vaulues[(addr-start)*2:(addr-start+quantity)*2]...)
+				return data, nil
+			} else {
+				data = append(data, block.vaulues[(addr-start)*2:]...)
+				return m.getFieldData(data, values, block.end+1, quantity-(block.end-addr+1))
+			}
 		}
-		dataFloat64 := cal(dataFloat64Before, fieldDetail.GetCoefficient()) + fieldDetail.Offset
+	}
+	return nil, fmt.Errorf("address %d not read", addr)
+}
+
+// setFieldValue parse data and set to the field value
+func (m *Modbus) setFieldValue(value reflect.Value, fieldDetail PointDetails, data []byte) error {
+	dataFloat64Before, err := parseDataToFloat64(data, fieldDetail.DataType, fieldDetail.OrderType)
+	if err != nil {
+		return err
+	}
+	dataFloat64 := cal(dataFloat64Before, fieldDetail.GetCoefficient()) + fieldDetail.Offset
 
-		switch value.Type().Kind() {
+	switch value.Type().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(int64(dataFloat64))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value.SetUint(uint64(dataFloat64))
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(dataFloat64)
+	case reflect.String:
+		if len(fieldDetail.Enum) > 0 {
+			name, err := m.enumName(fieldDetail, int(dataFloat64))
+			if err != nil {
+				return err
+			}
+			value.SetString(name)
+		} else {
+			value.SetString(byte2String(data))
+		}
+	case reflect.Pointer:
+		ptrType := value.Type().Elem()
+		newValue := reflect.New(ptrType)
+		switch ptrType.Kind() {
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			value.SetInt(int64(dataFloat64))
+			newValue.Elem().SetInt(int64(dataFloat64))
 		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			value.SetUint(uint64(dataFloat64))
+			newValue.Elem().SetUint(uint64(dataFloat64))
 		case reflect.Float32, reflect.Float64:
-			value.SetFloat(dataFloat64)
+			newValue.Elem().SetFloat(dataFloat64)
 		case reflect.String:
-			value.SetString(byte2String(data))
-		case reflect.Pointer:
-			ptrType := value.Type().Elem()
-			newValue := reflect.New(ptrType)
-			switch ptrType.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				newValue.Elem().SetInt(int64(dataFloat64))
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				newValue.Elem().SetUint(uint64(dataFloat64))
-			case reflect.Float32, reflect.Float64:
-				newValue.Elem().SetFloat(dataFloat64)
-			case reflect.String:
-				newValue.SetString(byte2String(data))
-			default:
-				return fmt.Errorf("parse for %s pointer not supported", value.Type().Kind())
-			}
-			value.Set(newValue)
-		case reflect.Slice, reflect.Array:
-			newSlice := reflect.MakeSlice(value.Type(), 0, 0)
-
-			if value.Type().Name() == "OriginByte" {
-				for _, b := range data {
-					newSlice = reflect.Append(newSlice, reflect.ValueOf(b))
+			if len(fieldDetail.Enum) > 0 {
+				name, err := m.enumName(fieldDetail, int(dataFloat64))
+				if err != nil {
+					return err
 				}
+				newValue.Elem().SetString(name)
 			} else {
-				size := 2
-				if fieldDetail.DataType == PointDataTypeU32 || fieldDetail.DataType == PointDataTypeS32 {
-					size = 4
-				}
-				for i := 0; i+size < len(data); i += size {
-					dataFloat64Before, err := parseDataToFloat64(data[i:i+size], fieldDetail.DataType, fieldDetail.OrderType)
-					if err != nil {
-						return err
-					}
-					newSlice = reflect.Append(newSlice, reflect.ValueOf(cal(dataFloat64Before, fieldDetail.GetCoefficient())+fieldDetail.Offset))
-				}
+				newValue.Elem().SetString(byte2String(data))
 			}
-			value.Set(newSlice)
 		default:
-			return fmt.Errorf("parse for %s not supported", value.Type().Kind())
+			return fmt.Errorf("parse for %s pointer not supported", value.Type().Kind())
+		}
+		value.Set(newValue)
+	case reflect.Slice, reflect.Array:
+		newSlice := reflect.MakeSlice(value.Type(), 0, 0)
+
+		if value.Type().Name() == "OriginByte" {
+			for _, b := range data {
+				newSlice = reflect.Append(newSlice, reflect.ValueOf(b))
+			}
+		} else {
+			size := 2
+			if fieldDetail.DataType == PointDataTypeU32 || fieldDetail.DataType == PointDataTypeS32 {
+				size = 4
+			}
+			for i := 0; i+size < len(data); i += size {
+				dataFloat64Before, err := parseDataToFloat64(data[i:i+size], fieldDetail.DataType, fieldDetail.OrderType)
+				if err != nil {
+					return err
+				}
+				newSlice = reflect.Append(newSlice, reflect.ValueOf(cal(dataFloat64Before, fieldDetail.GetCoefficient())+fieldDetail.Offset))
+			}
 		}
+		value.Set(newSlice)
+	default:
+		return fmt.Errorf("parse for %s not supported", value.Type().Kind())
 	}
 	return nil
 }
 
-func (m *Modbus) getFieldData(data []byte, values blocks, addr uint16, quantity uint16) []byte {
-	for start, block := range values {
-		if start <= addr && addr <= block.end {
-			if addr+quantity <= block.end {
-				data = append(data, block.vaulues[(addr-start)*2:(addr-start)*2+quantity*2]...)
-				return data
-			} else {
-				data = append(data, block.vaulues[addr-start:]...)
-				return m.getFieldData(data, values, block.end+1, quantity)
-			}
+func (m *Modbus) GetValuesSingle(ctx context.Context, v any, filter ...string) (err error) {
+	ctx, span := m.startSpan(ctx, "modbus.GetValues", Attribute{Key: "modbus.mode", Value: "single"})
+	defer func() { span.End(err) }()
+
+	filterMap := parseFilter(filter)
+	begin := time.Now()
+	defer func() {
+		if err != nil {
+			m.failSamples(v, filterMap, begin, err)
 		}
+	}()
+
+	// conn
+	sess, err := m.newSession(ctx)
+	if err != nil {
+		return err
 	}
-	return data
+	defer sess.close()
+
+	return m.getValuesSingle(ctx, sess, v, filterMap)
 }
 
-func (m *Modbus) GetValuesSingle(ctx context.Context, v any, filter ...string) error {
+// getValuesSingle read the points of v one by one, nested structs are read with the same connection
+func (m *Modbus) getValuesSingle(ctx context.Context, sess *session, v any, filterMap map[string]bool) error {
 	// validate v
 	val := reflect.ValueOf(v)
 	if val.Kind() != reflect.Ptr {
@@ -497,23 +1183,13 @@ func (m *Modbus) GetValuesSingle(ctx context.Context, v any, filter ...string) e
 	}
 
 	// filter
-	var needFilter bool
-	var filterMap map[string]bool
-	if len(filter) != 0 {
-		needFilter = true
-		filterMap = parseFilter(filter)
-	}
-
-	// conn
-	conn, err := m.connPool.Get()
-	if err != nil {
-		return fmt.Errorf("conn slave failed: %w", err)
-	}
-	defer m.connPool.Put(conn)
+	needFilter := len(filterMap) != 0
 
+	errs := &ValuesError{}
 	for i := 0; i < valueElem.NumField(); i++ {
 		value := valueElem.Field(i)
-		if value.Kind() == reflect.Struct {
+		sample, sampleValue, isSample := asSample(value)
+		if value.Kind() == reflect.Struct && !isSample {
 			// dive
 			if !value.CanAddr() {
 				continue
@@ -522,8 +1198,10 @@ func (m *Modbus) GetValuesSingle(ctx context.Context, v any, filter ...string) e
 			if !addr.IsValid() || !addr.CanInterface() {
 				continue
 			}
-			if e := m.GetValues(ctx, addr.Interface(), filter...); e != nil {
-				return e
+			if e := m.getValuesSingle(ctx, sess, addr.Interface(), filterMap); e != nil {
+				if !m.partialResults || !errs.merge(e) {
+					return e
+				}
 			}
 			continue
 		}
@@ -535,79 +1213,57 @@ func (m *Modbus) GetValuesSingle(ctx context.Context, v any, filter ...string) e
 			continue
 		}
 		fieldDetail, ok := m.points[fieldName]
-		if !ok {
+		if !ok || fieldDetail.Forbidden {
 			continue
 		}
-		data, err := m.readHoldingRegisters(conn, fieldDetail.Addr, fieldDetail.Quantity)
-		if err != nil {
-			return fmt.Errorf("ReadHoldingRegisters for %s failed, %w", fieldName, err)
-		}
-		dataFloat64Before, err := parseDataToFloat64(data, fieldDetail.DataType, fieldDetail.OrderType)
-		if err != nil {
-			return err
+		if isSample {
+			value = sampleValue
 		}
-		dataFloat64 := cal(dataFloat64Before, fieldDetail.GetCoefficient()) + fieldDetail.Offset
-		switch value.Type().Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			value.SetInt(int64(dataFloat64))
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			value.SetUint(uint64(dataFloat64))
-		case reflect.Float32, reflect.Float64:
-			value.SetFloat(dataFloat64)
-		case reflect.String:
-			value.SetString(byte2String(data))
-		case reflect.Pointer:
-			ptrType := value.Type().Elem()
-			newValue := reflect.New(ptrType)
-			switch ptrType.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				newValue.Elem().SetInt(int64(dataFloat64))
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				newValue.Elem().SetUint(uint64(dataFloat64))
-			case reflect.Float32, reflect.Float64:
-				newValue.Elem().SetFloat(dataFloat64)
-			case reflect.String:
-				newValue.SetString(byte2String(data))
-			default:
-				return fmt.Errorf("parse for %s pointer not supported", value.Type().Kind())
-			}
-			value.Set(newValue)
-		case reflect.Slice, reflect.Array:
-			newSlice := reflect.MakeSlice(value.Type(), 0, 0)
-
-			if value.Type().Name() == "OriginByte" {
-				for _, b := range data {
-					newSlice = reflect.Append(newSlice, reflect.ValueOf(b))
-				}
+		err := m.readFieldValue(sess, fieldName, fieldDetail, value)
+		if sample != nil {
+			if err != nil {
+				sample.setFailed(err, m.staleAfter)
 			} else {
-				size := 2
-				if fieldDetail.DataType == PointDataTypeU32 || fieldDetail.DataType == PointDataTypeS32 {
-					size = 4
-				}
-				for i := 0; i+size < len(data); i += size {
-					dataFloat64Before, err := parseDataToFloat64(data[i:i+size], fieldDetail.DataType, fieldDetail.OrderType)
-					if err != nil {
-						return err
-					}
-					newSlice = reflect.Append(newSlice, reflect.ValueOf(cal(dataFloat64Before, fieldDetail.GetCoefficient())+fieldDetail.Offset))
-				}
+				sample.setRead(time.Now())
 			}
-			value.Set(newSlice)
-		default:
-			return fmt.Errorf("parse for %s not supported", value.Type().Kind())
+		}
+		if err != nil {
+			if !m.partialResults {
+				return err
+			}
+			errs.add(fieldName, err)
 		}
 	}
+	return errs.errOrNil()
+}
+
+// readFieldValue read the registers of a point and set to the field value
+func (m *Modbus) readFieldValue(sess *session, fieldName string, fieldDetail PointDetails, value reflect.Value) error {
+	data, ok := m.cacheGet(fieldDetail)
+	if !ok {
+		var err error
+		generation := m.cacheGeneration()
+		data, err = m.readHoldingRegisters(sess, fieldDetail.Addr, fieldDetail.Quantity, []string{fieldName})
+		if err != nil {
+			return fmt.Errorf("ReadHoldingRegisters for %s failed, %w", fieldName, err)
+		}
+		m.cachePut(fieldDetail.Addr, data, time.Now(), generation)
+	}
+	if err := m.setFieldValue(value, fieldDetail, data); err != nil {
+		m.log.Warn("modbus decode failed", "point", fieldName, "error", err)
+		return err
+	}
 	return nil
 }
 
 // readHoldingRegisters allow to read quantiry larger than maxQuantity
-func (m *Modbus) readHoldingRegisters(conn Client, address uint16, quantity uint16) (results []byte, err error) {
+func (m *Modbus) readHoldingRegisters(sess *session, address uint16, quantity uint16, points []string) (results []byte, err error) {
 	if quantity <= m.maxQuantity {
-		return conn.ReadHoldingRegisters(address, quantity)
+		return sess.readHoldingRegisters(address, quantity, points)
 	}
 	for quantity > 0 {
 		currentQuantity := min(quantity, m.maxQuantity)
-		data, err := conn.ReadHoldingRegisters(address, currentQuantity)
+		data, err := sess.readHoldingRegisters(address, currentQuantity, points)
 		if err != nil {
 			return nil, err
 		}
@@ -625,6 +1281,13 @@ func min[T constraints.Ordered](a, b T) T {
 	return b
 }
 
+func max[T constraints.Ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func parseFilter(fields []string) map[string]bool {
 	m := map[string]bool{}
 	for _, field := range fields {
@@ -639,44 +1302,59 @@ func cal(before, c float64) float64 {
 }
 
 // SetValue set value to modbus from values.
-func (m *Modbus) SetValue(ctx context.Context, point string, value any) error {
+func (m *Modbus) SetValue(ctx context.Context, point string, value any) (err error) {
+	ctx, span := m.startSpan(ctx, "modbus.SetValue", Attribute{Key: "modbus.points", Value: []string{point}})
+	defer func() { span.End(err) }()
+
 	fieldDetail, ok := m.points[point]
 	if !ok {
 		return fmt.Errorf("point for %s not found", point)
 	}
 
-	var buf bytes.Buffer
-	err := binary.Write(&buf, binary.BigEndian, value)
-	if err != nil {
-		return err
+	var data []byte
+	if len(fieldDetail.Enum) > 0 {
+		// The state name or the code of the enumeration
+		code, err := m.enumValue(fieldDetail, reflect.ValueOf(value))
+		if err == nil {
+			data, err = enumData(fieldDetail, code)
+		}
+		if err != nil {
+			return fmt.Errorf("point %s: %w", point, err)
+		}
+	} else {
+		var buf bytes.Buffer
+		err = binary.Write(&buf, binary.BigEndian, value)
+		if err != nil {
+			return err
+		}
+		data = buf.Bytes()
 	}
-	data := buf.Bytes()
 
 	quantity := uint16(len(data) / 2)
 	if quantity != fieldDetail.Quantity {
 		return fmt.Errorf("value length not match, want %d, got %d", fieldDetail.Quantity, quantity)
 	}
 
-	conn, err := m.connPool.Get()
+	sess, err := m.newSession(ctx)
 	if err != nil {
-		return fmt.Errorf("conn slave failed: %w", err)
+		return err
 	}
-	defer m.connPool.Put(conn)
+	defer sess.close()
 
-	var writeErr error
 	if fieldDetail.Quantity == 1 {
-		_, writeErr = conn.WriteSingleRegister(fieldDetail.Addr, binary.BigEndian.Uint16(data))
-	} else {
-		_, writeErr = conn.WriteMultipleRegisters(fieldDetail.Addr, quantity, data)
+		return sess.writeSingleRegister(fieldDetail.Addr, binary.BigEndian.Uint16(data), fieldDetail.Idempotent, []string{point})
 	}
-	return writeErr
+	return sess.writeMultipleRegisters(fieldDetail.Addr, quantity, data, fieldDetail.Idempotent, []string{point})
 }
 
 // SetValues: Set values to modbus from v.
 /*
 	Fields need to be set should have tag "morm"
 */
-func (m *Modbus) SetValues(ctx context.Context, v any) error {
+func (m *Modbus) SetValues(ctx context.Context, v any) (err error) {
+	ctx, span := m.startSpan(ctx, "modbus.SetValues")
+	defer func() { span.End(err) }()
+
 	addrValue, err := m.gatherAddrValue(ctx, v)
 	if err != nil {
 		return errors.Wrap(err, "gatherAddrValue failed")
@@ -685,10 +1363,12 @@ func (m *Modbus) SetValues(ctx context.Context, v any) error {
 }
 
 type addrValue struct {
-	addr     uint16
-	quantity uint16
-	value    uint16
-	values   []byte
+	addr       uint16
+	quantity   uint16
+	value      uint16
+	values     []byte
+	idempotent bool
+	point      string
 }
 
 func (m *Modbus) gatherAddrValue(ctx context.Context, v any) ([]addrValue, error) {
@@ -710,7 +1390,8 @@ func (m *Modbus) gatherAddrValue(ctx context.Context, v any) ([]addrValue, error
 	addrValues := make([]addrValue, 0, fieldNum)
 	for i := 0; i < fieldNum; i++ {
 		value := valueElem.Field(i)
-		if value.Kind() == reflect.Struct {
+		_, sampleValue, isSample := asSample(value)
+		if value.Kind() == reflect.Struct && !isSample {
 			// dive
 			if !value.CanAddr() {
 				continue
@@ -726,6 +1407,9 @@ func (m *Modbus) gatherAddrValue(ctx context.Context, v any) ([]addrValue, error
 			addrValues = append(addrValues, sub...)
 			continue
 		}
+		if isSample {
+			value = sampleValue
+		}
 
 		if value.Kind() == reflect.Pointer {
 			if value.IsZero() {
@@ -742,6 +1426,22 @@ func (m *Modbus) gatherAddrValue(ctx context.Context, v any) ([]addrValue, error
 		if !ok {
 			continue
 		}
+		if len(fieldDetail.Enum) > 0 {
+			code, err := m.enumValue(fieldDetail, value)
+			if err != nil {
+				return nil, fmt.Errorf("point %s: %w", fieldName, err)
+			}
+			data, err := enumData(fieldDetail, code)
+			if err != nil {
+				return nil, fmt.Errorf("point %s: %w", fieldName, err)
+			}
+			if len(data) == 2 {
+				addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: 1, value: binary.BigEndian.Uint16(data), idempotent: fieldDetail.Idempotent, point: fieldName})
+			} else {
+				addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: uint16(len(data) / 2), values: data, idempotent: fieldDetail.Idempotent, point: fieldName})
+			}
+			continue
+		}
 		if fieldDetail.Quantity == 1 {
 			var valueFloat float64
 			if value.CanInt() {
@@ -753,32 +1453,32 @@ func (m *Modbus) gatherAddrValue(ctx context.Context, v any) ([]addrValue, error
 			} else {
 				continue
 			}
-			addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: fieldDetail.Quantity, value: uint16((valueFloat - fieldDetail.Offset) / fieldDetail.GetCoefficient())})
+			addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: fieldDetail.Quantity, value: uint16((valueFloat - fieldDetail.Offset) / fieldDetail.GetCoefficient()), idempotent: fieldDetail.Idempotent, point: fieldName})
 		} else {
 			// TODO: coefficent and offset
-			addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: fieldDetail.Quantity, values: []byte(value.String())})
+			addrValues = append(addrValues, addrValue{addr: fieldDetail.Addr, quantity: fieldDetail.Quantity, values: []byte(value.String()), idempotent: fieldDetail.Idempotent, point: fieldName})
 		}
 
 	}
 	return addrValues, nil
 }
 
-func (m *Modbus) writeValues(_ context.Context, addrValues []addrValue) error {
+func (m *Modbus) writeValues(ctx context.Context, addrValues []addrValue) error {
 	// conn
-	conn, err := m.connPool.Get()
+	sess, err := m.newSession(ctx)
 	if err != nil {
-		return fmt.Errorf("conn slave failed: %w", err)
+		return err
 	}
-	defer m.connPool.Put(conn)
+	defer sess.close()
 
 	// set
 	for _, v := range addrValues {
 		if v.quantity <= 1 {
-			if _, err := conn.WriteSingleRegister(v.addr, v.value); err != nil {
+			if err := sess.writeSingleRegister(v.addr, v.value, v.idempotent, []string{v.point}); err != nil {
 				return errors.Wrap(err, "WriteSingleRegister failed")
 			}
 		} else {
-			if _, err := conn.WriteMultipleRegisters(v.addr, v.quantity, v.values); err != nil {
+			if err := sess.writeMultipleRegisters(v.addr, v.quantity, v.values, v.idempotent, []string{v.point}); err != nil {
 				return errors.Wrap(err, "WriteMultipleRegisters failed")
 			}
 		}
@@ -0,0 +1,107 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// readValues points far enough apart to be read in separate blocks
+type readValues struct {
+	A uint16 `morm:"a"`
+	B uint16 `morm:"b"`
+	C uint16 `morm:"c"`
+}
+
+var readPoints = Point{
+	"a": {Addr: 0, Quantity: 1},
+	"b": {Addr: 200, Quantity: 1},
+	"c": {Addr: 400, Quantity: 1},
+}
+
+func newReadTestModbus(t *testing.T, host string, port int, opts ...ModbusOption) *Modbus {
+	opts = append([]ModbusOption{WithTimeout(time.Second), WithBlock(true), WithRequestDelay(0), WithMaxOpenConns(3), WithReadConcurrency(3)}, opts...)
+	m := NewModbusTCP(host, port, readPoints, opts...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestReadBlocksConcurrently(t *testing.T) {
+	d := newTestDevice()
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	d.setFault(func([]byte) []byte {
+		mutex.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mutex.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		return nil
+	})
+	host, port := serveTCP(t, d)
+	m := newReadTestModbus(t, host, port)
+
+	var v readValues
+	if err := m.GetValues(context.Background(), &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	if v.A != 0 || v.B != 200 || v.C != 400 {
+		t.Fatalf("GetValues() = %+v", v)
+	}
+	if maxInFlight != 3 {
+		t.Fatalf("max blocks in flight = %d, want 3", maxInFlight)
+	}
+}
+
+func TestReadBlocksConcurrentlyError(t *testing.T) {
+	d := newTestDevice()
+	d.setFault(func(pdu []byte) []byte {
+		switch addr := uint16(pdu[1])<<8 | uint16(pdu[2]); addr {
+		case 200:
+			// The later block fails first
+			time.Sleep(20 * time.Millisecond)
+			return exceptionPDU(pdu[0], modbus.ExceptionCodeServerDeviceFailure)
+		case 400:
+			return exceptionPDU(pdu[0], modbus.ExceptionCodeIllegalFunction)
+		}
+		return nil
+	})
+	host, port := serveTCP(t, d)
+	m := newReadTestModbus(t, host, port)
+
+	// The error of the lowest address is reported, whichever worker fails first
+	var v readValues
+	for i := 0; i < 3; i++ {
+		if code, ok := ExceptionCode(m.GetValues(context.Background(), &v)); !ok || code != modbus.ExceptionCodeServerDeviceFailure {
+			t.Fatalf("GetValues() exception = %d, %v, want server device failure", code, ok)
+		}
+	}
+}
+
+func TestReadBlocksConcurrentlyUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+	m := newReadTestModbus(t, addr.IP.String(), addr.Port, WithLazyConnect(true))
+
+	// No worker gets a connection, the blocks are failed with the dial errors
+	var v readValues
+	var opErr *net.OpError
+	if err := m.GetValues(context.Background(), &v); !errors.As(err, &opErr) || opErr.Op != "dial" {
+		t.Fatalf("GetValues() error = %v, want the dial error", err)
+	}
+}
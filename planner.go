@@ -0,0 +1,272 @@
+package modbusorm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CostModel cost of reading registers, used to plan the blocks
+/*
+	The cost of reading a block is RequestOverhead + PerRegister * quantity.
+	The planner finds the set of blocks with the minimum total cost,
+	so a gap is bridged only if reading it costs less than one more request.
+*/
+type CostModel struct {
+	// overhead of each request, like the round trip time
+	RequestOverhead time.Duration
+	// cost of each register read, like the transfer time of 2 bytes
+	PerRegister time.Duration
+}
+
+var defaultCostModel = CostModel{
+	RequestOverhead: 20 * time.Millisecond,
+	PerRegister:     200 * time.Microsecond,
+}
+
+// blockCost cost of reading quantity registers in one request
+func (c CostModel) blockCost(quantity uint16) float64 {
+	return float64(c.RequestOverhead) + float64(c.PerRegister)*float64(quantity)
+}
+
+// breakEvenGap the gap length at which bridging costs the same as one more request
+func (c CostModel) breakEvenGap() float64 {
+	if c.PerRegister <= 0 {
+		return math.Inf(1)
+	}
+	return float64(c.RequestOverhead) / float64(c.PerRegister)
+}
+
+// planConfig constraints of the planner
+type planConfig struct {
+	maxQuantity uint16
+	maxGap      uint16
+	unreadable  []AddrRange
+	cost        CostModel
+}
+
+// planBlocks find the minimum-cost set of blocks covering the requested ranges
+/*
+	Ranges are merged and split by maxQuantity first,
+	then the blocks are chosen by dynamic programming:
+	cost[j] = min(cost[i] + blockCost(ranges[i].Start ~ ranges[j-1].End)),
+	where no block is longer than maxQuantity, bridges a gap larger than maxGap,
+	or bridges an unreadable range.
+*/
+func planBlocks(requested []AddrRange, config planConfig) []*block {
+	ranges := normalizeRanges(requested, config.maxQuantity)
+	n := len(ranges)
+	if n == 0 {
+		return nil
+	}
+
+	cost := make([]float64, n+1)
+	from := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		cost[j] = math.Inf(1)
+		end := ranges[j-1].End
+		for i := j - 1; i >= 0; i-- {
+			start := ranges[i].Start
+			if uint32(end)-uint32(start)+1 > uint32(config.maxQuantity) {
+				break
+			}
+			if i < j-1 {
+				// the gap between ranges[i] and ranges[i+1] is bridged
+				gapStart, gapEnd := ranges[i].End+1, ranges[i+1].Start-1
+				if gapStart <= gapEnd && (gapEnd-gapStart+1 > config.maxGap || bridgesHole(config.unreadable, gapStart, gapEnd)) {
+					break
+				}
+			}
+			if c := cost[i] + config.cost.blockCost(end-start+1); c < cost[j] {
+				cost[j] = c
+				from[j] = i
+			}
+		}
+	}
+
+	// Walk back the choices
+	bs := make([]*block, 0)
+	for j := n; j > 0; j = from[j] {
+		i := from[j]
+		bs = append(bs, &block{start: ranges[i].Start, end: ranges[j-1].End, ranges: ranges[i:j]})
+	}
+	sort.Slice(bs, func(i, j int) bool { return bs[i].start < bs[j].start })
+	return bs
+}
+
+// normalizeRanges sort and merge the overlapping or adjacent ranges, and split those longer than maxQuantity
+func normalizeRanges(requested []AddrRange, maxQuantity uint16) []AddrRange {
+	if len(requested) == 0 {
+		return nil
+	}
+	sorted := append([]AddrRange{}, requested...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if uint32(r.Start) <= uint32(last.End)+1 {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	ranges := make([]AddrRange, 0, len(merged))
+	for _, r := range merged {
+		for uint32(r.End)-uint32(r.Start)+1 > uint32(maxQuantity) {
+			ranges = append(ranges, AddrRange{Start: r.Start, End: r.Start + maxQuantity - 1})
+			r.Start += maxQuantity
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// costEstimator measure the cost model from the observed latencies
+/*
+	It fits latency = RequestOverhead + PerRegister * quantity by least squares,
+	older samples decay so the model follows the link.
+*/
+type costEstimator struct {
+	mutex                       sync.Mutex
+	n, sumX, sumY, sumXY, sumXX float64
+}
+
+const (
+	costDecay      = 0.95
+	costMinSamples = 5
+)
+
+// observe record the latency of reading quantity registers
+func (e *costEstimator) observe(quantity uint16, latency time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	x, y := float64(quantity), float64(latency)
+	e.n = e.n*costDecay + 1
+	e.sumX = e.sumX*costDecay + x
+	e.sumY = e.sumY*costDecay + y
+	e.sumXY = e.sumXY*costDecay + x*y
+	e.sumXX = e.sumXX*costDecay + x*x
+}
+
+// model get the measured cost model, return false if not enough samples
+func (e *costEstimator) model() (CostModel, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.n < costMinSamples {
+		return CostModel{}, false
+	}
+	variance := e.n*e.sumXX - e.sumX*e.sumX
+	if variance <= 0 {
+		// all requests have the same quantity, can't tell the overhead from the register cost
+		return CostModel{}, false
+	}
+	perRegister := (e.n*e.sumXY - e.sumX*e.sumY) / variance
+	overhead := (e.sumY - perRegister*e.sumX) / e.n
+	return CostModel{
+		RequestOverhead: time.Duration(math.Max(overhead, 0)),
+		PerRegister:     time.Duration(math.Max(perRegister, 0)),
+	}, true
+}
+
+// currentCostModel get the measured cost model if enabled and available, otherwise the configured one
+func (m *Modbus) currentCostModel() CostModel {
+	if m.measureCost {
+		if c, ok := m.costs.model(); ok {
+			return c
+		}
+	}
+	return m.costModel
+}
+
+// maxBlockQuantity the max quantity of a block
+func (m *Modbus) maxBlockQuantity() uint16 {
+	if m.maxBlockSize != 0 && m.maxBlockSize < m.maxQuantity {
+		return m.maxBlockSize
+	}
+	return m.maxQuantity
+}
+
+// planKey plans are cached per struct type and filter set
+type planKey struct {
+	typ    reflect.Type
+	filter string
+}
+
+// cachedPlan blocks planned with the holes and cost model at that time
+type cachedPlan struct {
+	holesVersion int
+	breakEven    float64
+	blocks       []*block
+}
+
+type planCache struct {
+	mutex sync.Mutex
+	plans map[planKey]*cachedPlan
+}
+
+// planChangeRatio the cost model change that makes the cached plans out of date
+const planChangeRatio = 0.25
+
+// get get the cached plan if still up to date
+func (c *planCache) get(key planKey, holesVersion int, cost CostModel) ([]*block, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p, ok := c.plans[key]
+	if !ok || p.holesVersion != holesVersion {
+		return nil, false
+	}
+	breakEven := cost.breakEvenGap()
+	if math.IsInf(breakEven, 1) != math.IsInf(p.breakEven, 1) {
+		return nil, false
+	}
+	if !math.IsInf(breakEven, 1) && math.Abs(breakEven-p.breakEven) > p.breakEven*planChangeRatio {
+		return nil, false
+	}
+	return copyBlocks(p.blocks), true
+}
+
+func (c *planCache) put(key planKey, holesVersion int, cost CostModel, bs []*block) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.plans == nil {
+		c.plans = make(map[planKey]*cachedPlan)
+	}
+	c.plans[key] = &cachedPlan{holesVersion: holesVersion, breakEven: cost.breakEvenGap(), blocks: copyBlocks(bs)}
+}
+
+// copyBlocks copy the planned blocks, without values read
+func copyBlocks(bs []*block) []*block {
+	copied := make([]*block, 0, len(bs))
+	for _, b := range bs {
+		copied = append(copied, &block{start: b.start, end: b.end, ranges: b.ranges})
+	}
+	return copied
+}
+
+// formatBlocks format the address ranges of the blocks, for logging
+func formatBlocks(bs []*block) string {
+	parts := make([]string, 0, len(bs))
+	for _, b := range bs {
+		parts = append(parts, fmt.Sprintf("%d-%d", b.start, b.end))
+	}
+	return strings.Join(parts, ",")
+}
+
+// filterKey the key of the filter set, independent of the order
+func filterKey(filter []string) string {
+	sorted := append([]string{}, filter...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\x00")
+}
@@ -1,5 +1,7 @@
 package modbusorm
 
+import "time"
+
 // OriginByte the origin byte
 type OriginByte []byte
 
@@ -38,6 +40,18 @@ type PointDetails struct {
 	DataType PointDataType
 	// order type, like LittleEndian, represents the byte order is low byte first
 	OrderType OrderType
+	// forbidden, like true, represents the registers must never be read (e.g. write-only command registers),
+	// blocks will be split around them, but the point can still be written
+	Forbidden bool
+	// idempotent, like true, represents writing the point twice is the same as writing it once (e.g. setpoints),
+	// the writes will be retried by the retry policy
+	Idempotent bool
+	// cache ttl, like 10s, represents the registers read are reused for 10s, see WithCache,
+	// 0 means the default ttl, and a negative value means never cached
+	CacheTTL time.Duration
+	// enumeration, like {0: "STOPPED", 1: "RUNNING", 2: "FAULT"}, represents the state names of the codes,
+	// the string fields get the state names, and the integer fields get the codes, see WithStrictEnum
+	Enum map[int]string
 }
 
 // GetCoefficient get coefficient, if coefficient not set, return 1
@@ -47,3 +61,23 @@ func (p *PointDetails) GetCoefficient() float64 {
 	}
 	return p.Coefficient
 }
+
+// addrRange get the address range of the point
+func (p *PointDetails) addrRange() AddrRange {
+	var quantity uint16 = 1
+	if p.Quantity != 0 {
+		quantity = p.Quantity
+	}
+	return AddrRange{Start: p.Addr, End: p.Addr + quantity - 1}
+}
+
+// AddrRange address range, from Start to End (both included)
+type AddrRange struct {
+	Start uint16
+	End   uint16
+}
+
+// overlaps check if the range overlaps with [start, end]
+func (r AddrRange) overlaps(start, end uint16) bool {
+	return r.Start <= end && start <= r.End
+}
@@ -0,0 +1,289 @@
+package modbusorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PollGroup a group of points polled at the same interval
+type PollGroup struct {
+	// Name is the name of the group in the snapshots, default the struct type name
+	Name     string
+	Interval time.Duration
+	// Value is a struct pointer, like &Power{}, each snapshot is read into a new value of its type
+	Value any
+	// Filter is the points of Value to read, all if empty
+	Filter []string
+	// OnSnapshot is called with each snapshot of the group, instead of sending it to Poller.Snapshots
+	OnSnapshot func(Snapshot)
+}
+
+// PollerConfig config of the poller
+type PollerConfig struct {
+	Groups []PollGroup
+	// MergeWindow is the time a group due soon is read early, with the groups due now, default 0
+	MergeWindow time.Duration
+	// Buffer is the size of the Snapshots channel, the oldest snapshot is dropped when it is full, default 16
+	Buffer int
+}
+
+// Snapshot the values of a poll group read at a time
+type Snapshot struct {
+	Group string
+	// Value is a new struct pointer of the type of PollGroup.Value, filled with the values read
+	Value any
+	// Scheduled is the time the poll was due
+	Scheduled time.Time
+	// Time is the time the values were read
+	Time time.Time
+	// Missed is the number of polls skipped since the last snapshot, as the previous poll overran
+	Missed int
+	Err    error
+}
+
+// PollerStats statistics of the poller
+type PollerStats struct {
+	// Polls is the number of reads, the groups due at the same time are read together
+	Polls int64
+	// Overruns is the number of polls skipped as the previous poll overran the interval
+	Overruns int64
+	// Dropped is the number of snapshots dropped as the Snapshots channel is full
+	Dropped int64
+}
+
+// Poller poll the groups of points at their own intervals
+/*
+	The groups due at the same time are planned and read together, so the points of a fast group
+	and of a slow group near each other share the block reads, like WithBlock(true).
+	Without WithBlock, each group is read with GetValuesSingle.
+	With WithCache, each group is read through the cache, the groups due together share the registers cached instead.
+	The polls are scheduled from the start time, the delay of a poll doesn't shift the next ones,
+	and the polls missed while a poll overran are skipped and reported in Snapshot.Missed.
+*/
+type Poller struct {
+	m           *Modbus
+	groups      []*pollGroup
+	mergeWindow time.Duration
+	snapshots   chan Snapshot
+
+	polls    int64
+	overruns int64
+	dropped  int64
+
+	running int32
+	close   sync.Once
+}
+
+// pollGroup the schedule of a poll group
+type pollGroup struct {
+	PollGroup
+	typ       reflect.Type
+	filterMap map[string]bool
+	next      time.Time // the time the next poll is due
+	missed    int       // polls skipped since the last snapshot
+}
+
+// NewPoller allocates a Poller of the groups, call Run to start polling
+func NewPoller(m *Modbus, config PollerConfig) (*Poller, error) {
+	if len(config.Groups) == 0 {
+		return nil, fmt.Errorf("no poll group")
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 16
+	}
+	p := &Poller{
+		m:           m,
+		mergeWindow: config.MergeWindow,
+		snapshots:   make(chan Snapshot, config.Buffer),
+	}
+	names := make(map[string]bool, len(config.Groups))
+	for _, g := range config.Groups {
+		val := reflect.ValueOf(g.Value)
+		if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("value of poll group %s must be a struct pointer, got %T", g.Name, g.Value)
+		}
+		if g.Interval <= 0 {
+			return nil, fmt.Errorf("interval of poll group %s must be positive", g.Name)
+		}
+		typ := val.Elem().Type()
+		if g.Name == "" {
+			g.Name = typ.Name()
+		}
+		if names[g.Name] {
+			return nil, fmt.Errorf("duplicate poll group %s", g.Name)
+		}
+		names[g.Name] = true
+		p.groups = append(p.groups, &pollGroup{PollGroup: g, typ: typ, filterMap: parseFilter(g.Filter)})
+	}
+	return p, nil
+}
+
+// Snapshots the snapshots of the groups without OnSnapshot, closed when Run returns
+func (p *Poller) Snapshots() <-chan Snapshot {
+	return p.snapshots
+}
+
+// Stats Get the statistics of the poller
+func (p *Poller) Stats() PollerStats {
+	return PollerStats{
+		Polls:    atomic.LoadInt64(&p.polls),
+		Overruns: atomic.LoadInt64(&p.overruns),
+		Dropped:  atomic.LoadInt64(&p.dropped),
+	}
+}
+
+// Run poll the groups until ctx is done, all groups are polled at once first
+/*
+	Run must be called once, the Snapshots channel is closed when it returns.
+*/
+func (p *Poller) Run(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&p.running, 0, 1) {
+		panic("modbusorm: Poller.Run called twice")
+	}
+	defer p.close.Do(func() { close(p.snapshots) })
+
+	start := time.Now()
+	for _, g := range p.groups {
+		g.next = start
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		var due []*pollGroup
+		for _, g := range p.groups {
+			if !g.next.After(now.Add(p.mergeWindow)) {
+				due = append(due, g)
+			}
+		}
+		if len(due) > 0 {
+			p.poll(ctx, due)
+			if ctx.Err() != nil {
+				return
+			}
+			p.schedule(due, time.Now())
+		}
+		timer.Reset(time.Until(p.nextDue()))
+	}
+}
+
+// schedule schedule the next polls of the groups polled, skip the polls missed
+func (p *Poller) schedule(polled []*pollGroup, now time.Time) {
+	for _, g := range polled {
+		g.next = g.next.Add(g.Interval)
+		if g.next.After(now) {
+			continue
+		}
+		missed := int(now.Sub(g.next)/g.Interval) + 1
+		g.next = g.next.Add(time.Duration(missed) * g.Interval)
+		g.missed += missed
+		atomic.AddInt64(&p.overruns, int64(missed))
+		p.m.log.Warn("modbus poll overrun", "group", g.Name, "missed", missed)
+	}
+}
+
+// nextDue the time the next poll is due
+func (p *Poller) nextDue() time.Time {
+	next := p.groups[0].next
+	for _, g := range p.groups[1:] {
+		if g.next.Before(next) {
+			next = g.next
+		}
+	}
+	return next
+}
+
+// poll read the groups due, and deliver their snapshots
+func (p *Poller) poll(ctx context.Context, due []*pollGroup) {
+	atomic.AddInt64(&p.polls, 1)
+	values, errs := p.read(ctx, due)
+	if ctx.Err() != nil {
+		// Stopping, the reads were interrupted
+		return
+	}
+	now := time.Now()
+	for i, g := range due {
+		s := Snapshot{Group: g.Name, Value: values[i], Scheduled: g.next, Time: now, Missed: g.missed, Err: errs[i]}
+		g.missed = 0
+		p.deliver(g, s)
+	}
+}
+
+// read read the groups, the points of the groups share the block reads
+func (p *Poller) read(ctx context.Context, due []*pollGroup) (values []any, errs []error) {
+	names := make([]string, len(due))
+	values = make([]any, len(due))
+	errs = make([]error, len(due))
+	for i, g := range due {
+		names[i] = g.Name
+		values[i] = reflect.New(g.typ).Interface()
+	}
+	ctx, span := p.m.startSpan(ctx, "modbus.poll", Attribute{Key: "modbus.groups", Value: names})
+	defer span.End(nil)
+
+	// With the cache, the groups are read through it, sharing the registers cached and in flight with the other calls
+	if !p.m.withBlock || p.m.cacheTTL > 0 {
+		for i, g := range due {
+			errs[i] = p.m.GetValues(ctx, values[i], g.Filter...)
+		}
+		return
+	}
+
+	// The plans are cached per set of groups due together
+	sort.Strings(names)
+	key := planKey{filter: "poll\x00" + strings.Join(names, "\x00")}
+	bs, err := p.m.planRanges(ctx, key, "poll "+strings.Join(names, ","), func() ([]AddrRange, error) {
+		var ranges []AddrRange
+		for i, g := range due {
+			var err error
+			if ranges, err = p.m.collectRanges(ctx, values[i], ranges, g.filterMap); err != nil {
+				return nil, err
+			}
+		}
+		return ranges, nil
+	})
+	if err == nil {
+		err = p.m.readBlocks(ctx, bs)
+	}
+	for i, g := range due {
+		if err != nil {
+			errs[i] = err
+			p.m.failSamples(values[i], g.filterMap, time.Now(), err)
+			continue
+		}
+		errs[i] = p.m.setAddressValues(ctx, values[i], bs, g.filterMap)
+	}
+	return
+}
+
+// deliver call OnSnapshot of the group, or send the snapshot to the channel, drop the oldest one if it is full
+func (p *Poller) deliver(g *pollGroup, s Snapshot) {
+	if g.OnSnapshot != nil {
+		g.OnSnapshot(s)
+		return
+	}
+	for {
+		select {
+		case p.snapshots <- s:
+			return
+		default:
+		}
+		select {
+		case <-p.snapshots:
+			atomic.AddInt64(&p.dropped, 1)
+		default:
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package modbusorm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+type pollPower struct {
+	Power uint16 `morm:"power"`
+}
+
+type pollEnergy struct {
+	Energy uint16 `morm:"energy"`
+}
+
+func newPollerTestModbus(t *testing.T, d *testDevice, opts ...ModbusOption) *Modbus {
+	host, port := serveTCP(t, d)
+	points := Point{
+		"power":  {Addr: 10, Quantity: 1},
+		"energy": {Addr: 12, Quantity: 1},
+	}
+	opts = append([]ModbusOption{WithTimeout(time.Second), WithBlock(true), WithRequestDelay(0)}, opts...)
+	m := NewModbusTCP(host, port, points, opts...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// snapshotLog record the snapshots by group
+type snapshotLog struct {
+	mutex     sync.Mutex
+	snapshots map[string][]Snapshot
+}
+
+func (l *snapshotLog) record(s Snapshot) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.snapshots == nil {
+		l.snapshots = make(map[string][]Snapshot)
+	}
+	l.snapshots[s.Group] = append(l.snapshots[s.Group], s)
+}
+
+func (l *snapshotLog) count(group string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.snapshots[group])
+}
+
+// runPoller run the poller until cond is true, return once Run returned
+func runPoller(t *testing.T, p *Poller, cond func() bool) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	waitFor(t, "the polls", cond)
+	cancel()
+	<-done
+}
+
+// checkSchedule check the polls of the group are due at its interval from the start, the polls missed skipped
+func checkSchedule(t *testing.T, snapshots []Snapshot, interval time.Duration) {
+	t.Helper()
+	for i := 1; i < len(snapshots); i++ {
+		prev, s := snapshots[i-1], snapshots[i]
+		if gap := s.Scheduled.Sub(prev.Scheduled); gap != time.Duration(1+s.Missed)*interval {
+			t.Fatalf("snapshot %d of %s due %v after the previous with %d missed, want the interval %v", i, s.Group, gap, s.Missed, interval)
+		}
+		if s.Err != nil || s.Time.Before(s.Scheduled) {
+			t.Fatalf("snapshot %d of %s at %v due %v, error %v", i, s.Group, s.Time, s.Scheduled, s.Err)
+		}
+	}
+}
+
+func TestPollerSchedule(t *testing.T) {
+	d := newTestDevice()
+	m := newPollerTestModbus(t, d)
+	log := &snapshotLog{}
+	p, err := NewPoller(m, PollerConfig{Groups: []PollGroup{
+		{Interval: 20 * time.Millisecond, Value: &pollPower{}, OnSnapshot: log.record},
+		{Interval: 60 * time.Millisecond, Value: &pollEnergy{}, OnSnapshot: log.record},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPoller(t, p, func() bool { return log.count("pollEnergy") >= 3 })
+
+	power, energy := log.snapshots["pollPower"], log.snapshots["pollEnergy"]
+	checkSchedule(t, power, 20*time.Millisecond)
+	checkSchedule(t, energy, 60*time.Millisecond)
+	if !power[0].Scheduled.Equal(energy[0].Scheduled) {
+		t.Fatal("groups not polled at once first")
+	}
+	if v := power[0].Value.(*pollPower); v.Power != 10 {
+		t.Fatalf("power = %d, want 10", v.Power)
+	}
+	if v := energy[0].Value.(*pollEnergy); v.Energy != 12 {
+		t.Fatalf("energy = %d, want 12", v.Energy)
+	}
+	// The groups due together share one block read
+	stats := p.Stats()
+	if n := d.requestCount(modbus.FuncCodeReadHoldingRegisters); int64(n) != stats.Polls || int64(len(power)+len(energy)) <= stats.Polls {
+		t.Fatalf("%d reads for %d polls of %d snapshots, want a read per poll", n, stats.Polls, len(power)+len(energy))
+	}
+}
+
+func TestPollerOverrun(t *testing.T) {
+	d := newTestDevice()
+	d.setFault(func([]byte) []byte {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	m := newPollerTestModbus(t, d)
+	log := &snapshotLog{}
+	p, err := NewPoller(m, PollerConfig{Groups: []PollGroup{
+		{Interval: 20 * time.Millisecond, Value: &pollPower{}, OnSnapshot: log.record},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPoller(t, p, func() bool { return log.count("pollPower") >= 3 })
+
+	snapshots := log.snapshots["pollPower"]
+	checkSchedule(t, snapshots, 20*time.Millisecond)
+	var missed int64
+	for _, s := range snapshots {
+		missed += int64(s.Missed)
+	}
+	// The polls missed after the last snapshot are not reported yet
+	if stats := p.Stats(); missed == 0 || stats.Overruns < missed {
+		t.Fatalf("Overruns = %d, %d missed in the snapshots", stats.Overruns, missed)
+	}
+}
+
+func TestPollerStop(t *testing.T) {
+	m := newPollerTestModbus(t, newTestDevice())
+	p, err := NewPoller(m, PollerConfig{
+		Groups: []PollGroup{{Interval: 5 * time.Millisecond, Value: &pollPower{}}},
+		Buffer: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No one receives, the oldest snapshots are dropped
+	runPoller(t, p, func() bool { return p.Stats().Dropped >= 2 })
+
+	var received int
+	for s := range p.Snapshots() {
+		if s.Err != nil {
+			t.Fatalf("snapshot error = %v", s.Err)
+		}
+		received++
+	}
+	if received != 1 {
+		t.Fatalf("snapshots left = %d, want the last one", received)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Run() again did not panic")
+		}
+	}()
+	p.Run(context.Background())
+}
+
+func TestPollerCache(t *testing.T) {
+	d := newTestDevice()
+	m := newPollerTestModbus(t, d, WithCache(time.Minute))
+	var v pollPower
+	if err := m.GetValues(context.Background(), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	// The poll is served by the registers cached
+	log := &snapshotLog{}
+	p, err := NewPoller(m, PollerConfig{Groups: []PollGroup{
+		{Interval: time.Hour, Value: &pollPower{}, OnSnapshot: log.record},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPoller(t, p, func() bool { return log.count("pollPower") == 1 })
+	if s := log.snapshots["pollPower"][0]; s.Err != nil || s.Value.(*pollPower).Power != 10 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	if n := d.requestCount(modbus.FuncCodeReadHoldingRegisters); n != 1 {
+		t.Fatalf("reads = %d, want the poll read from the cache", n)
+	}
+}
+
+func TestNewPollerInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []PollGroup
+	}{
+		{"no group", nil},
+		{"not a struct pointer", []PollGroup{{Interval: time.Second, Value: pollPower{}}}},
+		{"no interval", []PollGroup{{Value: &pollPower{}}}},
+		{"duplicate name", []PollGroup{{Interval: time.Second, Value: &pollPower{}}, {Interval: time.Minute, Value: &pollPower{}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewPoller(&Modbus{}, PollerConfig{Groups: tt.groups}); err == nil {
+			t.Errorf("NewPoller(%s) error = nil", tt.name)
+		}
+	}
+}
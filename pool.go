@@ -1,6 +1,9 @@
 package modbusorm
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/goburrow/modbus"
@@ -12,10 +15,103 @@ type Client interface {
 	Close() error
 	IsAlive() bool
 	CreateTime() time.Time
+	// SetSlaveID set the slave id of the following requests, for devices sharing the connection
+	SetSlaveID(slaveID uint8)
 }
 
 type ConnPool interface {
-	Get() (Client, error)
+	// Get get a connection, wait until one is available or ctx is done
+	Get(ctx context.Context) (Client, error)
 	Put(conn Client) error
 	Close() error
+	Stats() PoolStats
+}
+
+// PoolStats statistics of the connection pool, like database/sql.DBStats
+type PoolStats struct {
+	MaxOpenConnections int // Maximum number of open connections.
+
+	// Pool Status
+	OpenConnections int // The number of established connections both in use and idle.
+	InUse           int // The number of connections currently in use.
+	Idle            int // The number of idle connections.
+
+	// Counters
+	WaitCount         int64         // The total number of connections waited for.
+	WaitDuration      time.Duration // The total time blocked waiting for a new connection.
+	MaxIdleClosed     int64         // The total number of connections closed due to MaxIdleConns.
+	MaxIdleTimeClosed int64         // The total number of connections closed due to ConnMaxIdleTime.
+	MaxLifetimeClosed int64         // The total number of connections closed due to ConnMaxLifetime.
+}
+
+// ConnState state of the connection to the device
+type ConnState uint8
+
+const (
+	ConnStateConnecting ConnState = iota // dialing the device, or not dialed yet in lazy mode
+	ConnStateUp                          // the device is connected
+	ConnStateDown                        // the last dial failed, reconnecting in the background
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateConnecting:
+		return "connecting"
+	case ConnStateUp:
+		return "up"
+	case ConnStateDown:
+		return "down"
+	}
+	return "unknown"
+}
+
+// stateWatcher the connection pool reporting the connection state
+type stateWatcher interface {
+	State() ConnState
+	WatchState(ctx context.Context) <-chan ConnState
+}
+
+// Probe health check probe of an idle connection, return an error if the connection is bad
+/*
+	The probes return only the transport errors, an exception response means the device is reachable,
+	so the connection is still good.
+*/
+type Probe func(conn Client) error
+
+// diagnoser the client supporting diagnostics requests (FC08)
+type diagnoser interface {
+	Diagnostics(subFunction uint16, data []byte) (results []byte, err error)
+}
+
+// ProbeDiagnosticsEcho probe with a diagnostics return query data request (FC08, sub-function 0)
+/*
+	The device must echo the data sent, clients without diagnostics requests are not probed.
+*/
+func ProbeDiagnosticsEcho() Probe {
+	echo := []byte{0x4d, 0x4f}
+	return func(conn Client) error {
+		d, ok := conn.(diagnoser)
+		if !ok {
+			return nil
+		}
+		results, err := d.Diagnostics(0, echo)
+		if isTransportError(err) {
+			return err
+		}
+		if err == nil && !bytes.Equal(results, echo) {
+			return fmt.Errorf("diagnostics echo mismatch, want % x, got % x", echo, results)
+		}
+		return nil
+	}
+}
+
+// ProbeRegister probe by reading the holding register at addr
+func ProbeRegister(addr uint16) Probe {
+	return func(conn Client) error {
+		_, err := conn.ReadHoldingRegisters(addr, 1)
+		if isTransportError(err) {
+			return err
+		}
+		return nil
+	}
 }
@@ -0,0 +1,60 @@
+package modbusorm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestProbe(t *testing.T) {
+	exception := func(pdu []byte) []byte {
+		return exceptionPDU(pdu[0], modbus.ExceptionCodeIllegalFunction)
+	}
+	wrongEcho := func(pdu []byte) []byte {
+		if pdu[0] != funcCodeDiagnostics {
+			return nil
+		}
+		return []byte{pdu[0], 0, 0, 0, 0}
+	}
+	// The device answers after the probe timed out
+	silent := func([]byte) []byte {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}
+	tests := []struct {
+		name  string
+		probe Probe
+		fault func(pdu []byte) []byte
+		// function is the function code of the probe request
+		function byte
+		wantErr  bool
+	}{
+		{"echo good", ProbeDiagnosticsEcho(), nil, funcCodeDiagnostics, false},
+		{"echo exception", ProbeDiagnosticsEcho(), exception, funcCodeDiagnostics, false},
+		{"echo mismatch", ProbeDiagnosticsEcho(), wrongEcho, funcCodeDiagnostics, true},
+		{"echo transport error", ProbeDiagnosticsEcho(), silent, funcCodeDiagnostics, true},
+		{"register good", ProbeRegister(10), nil, modbus.FuncCodeReadHoldingRegisters, false},
+		{"register exception", ProbeRegister(10), exception, modbus.FuncCodeReadHoldingRegisters, false},
+		{"register transport error", ProbeRegister(10), silent, modbus.FuncCodeReadHoldingRegisters, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDevice()
+			d.setFault(tt.fault)
+			conn, err := newTestDialer(t, d).dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.(*ModbusTCPClient).Handler.Timeout = 50 * time.Millisecond
+
+			if err := tt.probe(conn); (err != nil) != tt.wantErr {
+				t.Fatalf("probe error = %v, want error %v", err, tt.wantErr)
+			}
+			if n := d.requestCount(tt.function); n != 1 {
+				t.Fatalf("probe requests = %d, want 1", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package modbusorm
+
+// Quality quality of a value, like the OPC quality
+type Quality uint8
+
+const (
+	// QualityBad the value couldn't be read, or is too old to be used
+	QualityBad Quality = iota
+	// QualityUncertain the value is the last one read, the latest read failed
+	QualityUncertain
+	// QualityGood the value is read from the device
+	QualityGood
+)
+
+func (q Quality) String() string {
+	switch q {
+	case QualityBad:
+		return "bad"
+	case QualityUncertain:
+		return "uncertain"
+	case QualityGood:
+		return "good"
+	}
+	return "unknown"
+}
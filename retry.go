@@ -0,0 +1,272 @@
+package modbusorm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// RetryPolicy retry policy of the modbus requests
+/*
+	A failed request is sent again up to MaxAttempts in total, waiting an exponential backoff with jitter,
+	from MinBackoff to MaxBackoff. After a transport error, the request is retried on a fresh pooled connection.
+	Writes are retried only if all their points are marked Idempotent.
+*/
+type RetryPolicy struct {
+	// MaxAttempts is the max number of attempts, including the first one, 1 or less means no retry
+	MaxAttempts int
+	MinBackoff  time.Duration
+	// MaxBackoff is the max wait between attempts, 0 means no limit
+	MaxBackoff time.Duration
+	// Retryable reports whether the error should be retried, default IsRetryable
+	Retryable func(err error) bool
+}
+
+// IsRetryable the default retryable errors
+/*
+	Transport errors, like timeouts and closed connections, and the exceptions of a busy device
+	or an unreachable gateway target are retried.
+	Other exceptions, like illegal data address, are answers of the device and won't change,
+	and the requests rejected by an interceptor are not retried.
+*/
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrPoolClosed) || errors.Is(err, ErrDeviceUnavailable) || errors.Is(err, ErrRequestRejected) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var mbErr *modbus.ModbusError
+	if !errors.As(err, &mbErr) {
+		return true
+	}
+	switch mbErr.ExceptionCode {
+	case modbus.ExceptionCodeServerDeviceBusy,
+		modbus.ExceptionCodeAcknowledge,
+		modbus.ExceptionCodeGatewayPathUnavailable,
+		modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond:
+		return true
+	}
+	return false
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff the wait before the next attempt, attempt starts from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := p.MinBackoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return withJitter(backoff)
+}
+
+// RetryError the error of a request failed after retries
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryStats retry statistics of the modbus
+type RetryStats struct {
+	// Retries is the number of attempts sent again
+	Retries int64
+	// Recovered is the number of requests succeeded after retries
+	Recovered int64
+	// Failed is the number of requests still failed after retries
+	Failed int64
+}
+
+type retryCounters struct {
+	retries   int64
+	recovered int64
+	failed    int64
+}
+
+// RetryStats Get the retry statistics
+func (m *Modbus) RetryStats() RetryStats {
+	return RetryStats{
+		Retries:   atomic.LoadInt64(&m.retryCounters.retries),
+		Recovered: atomic.LoadInt64(&m.retryCounters.recovered),
+		Failed:    atomic.LoadInt64(&m.retryCounters.failed),
+	}
+}
+
+// session a connection checked out for a call, replaced by a fresh one after a transport error
+type session struct {
+	m    *Modbus
+	ctx  context.Context
+	conn Client
+	// retried reports whether the last request took more than one attempt
+	retried bool
+}
+
+// newSession check out a connection for the call
+func (m *Modbus) newSession(ctx context.Context) (*session, error) {
+	if err := m.breaker.check(); err != nil {
+		return nil, err
+	}
+	conn, err := m.getConn(ctx)
+	if err != nil {
+		m.breaker.failed(err)
+		return nil, fmt.Errorf("conn slave failed: %w", err)
+	}
+	return &session{m: m, ctx: ctx, conn: conn}, nil
+}
+
+// close give back the connection
+func (s *session) close() {
+	if s.conn != nil {
+		s.m.putConn(s.conn)
+		s.conn = nil
+	}
+}
+
+// do send the request, retry by the retry policy
+func (s *session) do(req Request) ([]byte, error) {
+	policy := s.m.retryPolicy
+	for attempt := 1; ; attempt++ {
+		s.retried = attempt > 1
+		req.Attempt = attempt
+		results, err := s.send(req)
+		if err == nil {
+			if attempt > 1 {
+				atomic.AddInt64(&s.m.retryCounters.recovered, 1)
+			}
+			return results, nil
+		}
+		if isTransportError(err) {
+			// The connection is closed by the pool as it is not alive, the next request takes a fresh one
+			s.close()
+		}
+		if attempt >= policy.MaxAttempts || (req.IsWrite() && !req.Idempotent) || !policy.retryable(err) {
+			if attempt > 1 {
+				atomic.AddInt64(&s.m.retryCounters.failed, 1)
+				return nil, &RetryError{Attempts: attempt, Err: err}
+			}
+			return nil, err
+		}
+
+		backoff := policy.backoff(attempt)
+		s.m.log.Warn("modbus request retry", s.m.requestFields(req, "backoff", backoff, "error", err)...)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return nil, &RetryError{Attempts: attempt, Err: err}
+		case <-timer.C:
+		}
+		atomic.AddInt64(&s.m.retryCounters.retries, 1)
+	}
+}
+
+// send send the request through the interceptors on the connection, check out a fresh one if needed
+func (s *session) send(req Request) ([]byte, error) {
+	if err := s.m.breaker.allow(); err != nil {
+		return nil, err
+	}
+	if s.conn == nil {
+		conn, err := s.m.getConn(s.ctx)
+		if err != nil {
+			s.m.breaker.done(err)
+			return nil, fmt.Errorf("conn slave failed: %w", err)
+		}
+		s.conn = conn
+	}
+	conn := s.conn
+	// The errors not returned by the device are the rejections of the interceptors
+	var reached bool
+	var deviceErr error
+	invoker := chainInterceptors(s.m.interceptors, func(ctx context.Context, req Request) (Response, error) {
+		resp, err := invoke(conn, req)
+		reached, deviceErr = true, err
+		return resp, err
+	})
+
+	ctx, span := s.m.startSpan(s.ctx, "modbus.request", requestAttributes(req)...)
+	begin := time.Now()
+	resp, err := invoker(ctx, req)
+	err = rejected(err, reached, deviceErr)
+	span.End(err)
+	s.m.breaker.done(err)
+	if req.IsWrite() {
+		// Even a failed write may have changed the registers
+		invalidateCache(s.m.target(), modbus.FuncCodeReadHoldingRegisters, req.Address, req.Quantity)
+	}
+	sent, received := pduSizes(req, resp, err)
+	s.m.metrics.ObserveRequest(s.m.target(), RequestObservation{
+		Request:       req,
+		Duration:      time.Since(begin),
+		BytesSent:     sent,
+		BytesReceived: received,
+		Err:           err,
+	})
+	if err != nil {
+		s.m.log.Debug("modbus request failed", s.m.requestFields(req, "duration", time.Since(begin), "error", err)...)
+		return nil, err
+	}
+	s.m.log.Debug("modbus request", s.m.requestFields(req, "duration", time.Since(begin))...)
+	return resp.Data, nil
+}
+
+// requestFields the log fields of the request, followed by args
+func (m *Modbus) requestFields(req Request, args ...any) []any {
+	fields := []any{"slave_id", req.SlaveID, "function_code", req.FunctionCode, "address", req.Address, "quantity", req.Quantity, "attempt", req.Attempt}
+	return append(fields, args...)
+}
+
+func (s *session) readHoldingRegisters(address, quantity uint16, points []string) ([]byte, error) {
+	return s.do(Request{
+		FunctionCode: modbus.FuncCodeReadHoldingRegisters,
+		Address:      address,
+		Quantity:     quantity,
+		SlaveID:      s.m.slaveID,
+		Points:       points,
+	})
+}
+
+func (s *session) writeSingleRegister(address, value uint16, idempotent bool, points []string) error {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, value)
+	_, err := s.do(Request{
+		FunctionCode: modbus.FuncCodeWriteSingleRegister,
+		Address:      address,
+		Quantity:     1,
+		Payload:      payload,
+		SlaveID:      s.m.slaveID,
+		Points:       points,
+		Idempotent:   idempotent,
+	})
+	return err
+}
+
+func (s *session) writeMultipleRegisters(address, quantity uint16, value []byte, idempotent bool, points []string) error {
+	_, err := s.do(Request{
+		FunctionCode: modbus.FuncCodeWriteMultipleRegisters,
+		Address:      address,
+		Quantity:     quantity,
+		Payload:      value,
+		SlaveID:      s.m.slaveID,
+		Points:       points,
+		Idempotent:   idempotent,
+	})
+	return err
+}
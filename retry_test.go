@@ -0,0 +1,188 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		// base is the backoff before the jitter, the jitter keeps it in [base/2, base)
+		base time.Duration
+	}{
+		{"first attempt", RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 80 * time.Millisecond}, 1, 10 * time.Millisecond},
+		{"doubled", RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 80 * time.Millisecond}, 3, 40 * time.Millisecond},
+		{"max", RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 80 * time.Millisecond}, 4, 80 * time.Millisecond},
+		{"capped", RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 80 * time.Millisecond}, 10, 80 * time.Millisecond},
+		{"max below min", RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 5 * time.Millisecond}, 2, 5 * time.Millisecond},
+		{"no max", RetryPolicy{MinBackoff: 10 * time.Millisecond}, 3, 40 * time.Millisecond},
+		{"no backoff", RetryPolicy{}, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := tt.policy.backoff(tt.attempt)
+				if tt.base == 0 && got != 0 {
+					t.Fatalf("backoff(%d) = %v, want 0", tt.attempt, got)
+				}
+				if tt.base > 0 && (got < tt.base/2 || got >= tt.base) {
+					t.Fatalf("backoff(%d) = %v, want in [%v, %v)", tt.attempt, got, tt.base/2, tt.base)
+				}
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	exception := func(code byte) error {
+		return fmt.Errorf("read failed: %w", &modbus.ModbusError{FunctionCode: modbus.FuncCodeReadHoldingRegisters, ExceptionCode: code})
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"transport error", io.EOF, true},
+		{"timeout", fmt.Errorf("read failed: %w", &timeoutError{}), true},
+		{"device busy", exception(modbus.ExceptionCodeServerDeviceBusy), true},
+		{"acknowledge", exception(modbus.ExceptionCodeAcknowledge), true},
+		{"gateway path unavailable", exception(modbus.ExceptionCodeGatewayPathUnavailable), true},
+		{"gateway target failed", exception(modbus.ExceptionCodeGatewayTargetDeviceFailedToRespond), true},
+		{"illegal data address", exception(modbus.ExceptionCodeIllegalDataAddress), false},
+		{"device failure", exception(modbus.ExceptionCodeServerDeviceFailure), false},
+		{"pool closed", fmt.Errorf("conn slave failed: %w", ErrPoolClosed), false},
+		{"breaker open", ErrDeviceUnavailable, false},
+		{"interceptor rejection", fmt.Errorf("%w: read only", ErrRequestRejected), false},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("conn slave failed: %w", context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// timeoutError a net.Error timing out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// busyFor answer the requests of the function with a busy exception n times
+func busyFor(function byte, n int) func(pdu []byte) []byte {
+	var mutex sync.Mutex
+	return func(pdu []byte) []byte {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if pdu[0] != function || n == 0 {
+			return nil
+		}
+		n--
+		return exceptionPDU(pdu[0], modbus.ExceptionCodeServerDeviceBusy)
+	}
+}
+
+func newRetryTestModbus(t *testing.T, d *testDevice, opts ...ModbusOption) *Modbus {
+	host, port := serveTCP(t, d)
+	points := Point{
+		"power":    {Addr: 10, Quantity: 1},
+		"setpoint": {Addr: 20, Quantity: 1, Idempotent: true},
+		"command":  {Addr: 21, Quantity: 1},
+	}
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	m := NewModbusTCP(host, port, points, append([]ModbusOption{WithTimeout(time.Second), WithRetryPolicy(policy)}, opts...)...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		function byte
+		busy     int
+		call     func(m *Modbus) error
+		// attempts is the number of requests sent
+		attempts int
+		wantErr  bool
+		want     RetryStats
+	}{
+		{
+			name: "read recovered", function: modbus.FuncCodeReadHoldingRegisters, busy: 2,
+			call:     func(m *Modbus) error { var v uint16; return m.GetValue(context.Background(), "power", &v) },
+			attempts: 3, want: RetryStats{Retries: 2, Recovered: 1},
+		},
+		{
+			name: "read failed", function: modbus.FuncCodeReadHoldingRegisters, busy: 5,
+			call:     func(m *Modbus) error { var v uint16; return m.GetValue(context.Background(), "power", &v) },
+			attempts: 3, wantErr: true, want: RetryStats{Retries: 2, Failed: 1},
+		},
+		{
+			name: "idempotent write retried", function: modbus.FuncCodeWriteSingleRegister, busy: 1,
+			call:     func(m *Modbus) error { return m.SetValue(context.Background(), "setpoint", uint16(7)) },
+			attempts: 2, want: RetryStats{Retries: 1, Recovered: 1},
+		},
+		{
+			name: "write not retried", function: modbus.FuncCodeWriteSingleRegister, busy: 1,
+			call:     func(m *Modbus) error { return m.SetValue(context.Background(), "command", uint16(7)) },
+			attempts: 1, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDevice()
+			d.setFault(busyFor(tt.function, tt.busy))
+			m := newRetryTestModbus(t, d)
+
+			err := tt.call(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			var retryErr *RetryError
+			if isRetryErr := errors.As(err, &retryErr); isRetryErr != (tt.wantErr && tt.attempts > 1) {
+				t.Fatalf("error = %v, want a retry error %v", err, tt.wantErr && tt.attempts > 1)
+			} else if isRetryErr && retryErr.Attempts != tt.attempts {
+				t.Fatalf("Attempts = %d, want %d", retryErr.Attempts, tt.attempts)
+			}
+			if n := d.requestCount(tt.function); n != tt.attempts {
+				t.Fatalf("requests sent = %d, want %d", n, tt.attempts)
+			}
+			if stats := m.RetryStats(); stats != tt.want {
+				t.Fatalf("RetryStats() = %+v, want %+v", stats, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryableOverride(t *testing.T) {
+	d := newTestDevice(10)
+	policy := RetryPolicy{MaxAttempts: 2, Retryable: func(err error) bool {
+		code, ok := ExceptionCode(err)
+		return ok && code == modbus.ExceptionCodeIllegalDataAddress
+	}}
+	m := newRetryTestModbus(t, d, WithRetryPolicy(policy))
+
+	var v uint16
+	if err := m.GetValue(context.Background(), "power", &v); err == nil {
+		t.Fatal("GetValue() error = nil")
+	}
+	if n := d.requestCount(modbus.FuncCodeReadHoldingRegisters); n != 2 {
+		t.Fatalf("requests sent = %d, want the exception retried", n)
+	}
+}
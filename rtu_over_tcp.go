@@ -0,0 +1,170 @@
+package modbusorm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// RTUOverTCPClientHandler send RTU frames (with CRC) over a TCP connection, like serial-to-Ethernet converters
+/*
+	The RTU framing of modbus.RTUClientHandler is used, Address is the host:port of the converter,
+	the serial settings of modbus.RTUClientHandler are ignored.
+*/
+type RTUOverTCPClientHandler struct {
+	modbus.RTUClientHandler
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// NewRTUOverTCPClientHandler allocates a RTUOverTCPClientHandler
+func NewRTUOverTCPClientHandler(address string) *RTUOverTCPClientHandler {
+	handler := &RTUOverTCPClientHandler{}
+	handler.Address = address
+	handler.Timeout = 10 * time.Second
+	return handler
+}
+
+// Connect dial the converter
+func (h *RTUOverTCPClientHandler) Connect() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.connect()
+}
+
+func (h *RTUOverTCPClientHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	dialer := net.Dialer{Timeout: h.Timeout}
+	conn, err := dialer.Dial("tcp", h.Address)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+// Close close the connection
+func (h *RTUOverTCPClientHandler) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.close()
+}
+
+func (h *RTUOverTCPClientHandler) close() error {
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send send a RTU frame and read the response frame
+/*
+	There is no length in a RTU frame and no silent interval on TCP,
+	so the length of the response is worked out from the function code and the byte count.
+	The connection is closed on error, the rest of a bad frame would corrupt the next response.
+*/
+func (h *RTUOverTCPClientHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err = h.connect(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			h.close()
+		}
+	}()
+
+	var deadline time.Time
+	if h.Timeout > 0 {
+		deadline = time.Now().Add(h.Timeout)
+	}
+	if err = h.conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	if _, err = h.conn.Write(aduRequest); err != nil {
+		return
+	}
+
+	// slave id, function code and the first byte of the data
+	data := make([]byte, rtuMaxSize)
+	if _, err = io.ReadFull(h.conn, data[:3]); err != nil {
+		return
+	}
+	length, err := rtuResponseLength(data[:3], aduRequest)
+	if err != nil {
+		return
+	}
+	if length > rtuMaxSize {
+		return nil, fmt.Errorf("modbus: response length '%v' must not be bigger than '%v'", length, rtuMaxSize)
+	}
+	if length == 0 {
+		// read FIFO queue, the byte count takes 2 bytes
+		if _, err = io.ReadFull(h.conn, data[3:4]); err != nil {
+			return
+		}
+		length = 4 + int(binary.BigEndian.Uint16(data[2:])) + 2
+		if length > rtuMaxSize {
+			return nil, fmt.Errorf("modbus: response length '%v' must not be bigger than '%v'", length, rtuMaxSize)
+		}
+		if _, err = io.ReadFull(h.conn, data[4:length]); err != nil {
+			return
+		}
+		return data[:length], nil
+	}
+	if _, err = io.ReadFull(h.conn, data[3:length]); err != nil {
+		return
+	}
+	return data[:length], nil
+}
+
+const (
+	rtuMaxSize       = 256
+	rtuExceptionSize = 5
+)
+
+// rtuResponseLength the length of the response frame from its first 3 bytes, 0 if it takes a 2 bytes byte count
+func rtuResponseLength(head []byte, aduRequest []byte) (int, error) {
+	function := head[1]
+	if function&0x80 != 0 {
+		return rtuExceptionSize, nil
+	}
+	if function != aduRequest[1] {
+		return 0, fmt.Errorf("modbus: response function '%v' does not match request '%v'", function, aduRequest[1])
+	}
+	switch function {
+	case modbus.FuncCodeReadCoils,
+		modbus.FuncCodeReadDiscreteInputs,
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeReadInputRegisters,
+		modbus.FuncCodeReadWriteMultipleRegisters:
+		// slave id, function code, byte count, data and CRC
+		return 3 + int(head[2]) + 2, nil
+	case modbus.FuncCodeWriteSingleCoil,
+		modbus.FuncCodeWriteMultipleCoils,
+		modbus.FuncCodeWriteSingleRegister,
+		modbus.FuncCodeWriteMultipleRegisters:
+		return 8, nil
+	case modbus.FuncCodeMaskWriteRegister:
+		return 10, nil
+	case modbus.FuncCodeReadFIFOQueue:
+		return 0, nil
+	case funcCodeDiagnostics:
+		// echo of the request
+		return len(aduRequest), nil
+	}
+	return 0, fmt.Errorf("modbus: function code '%v' is not supported over TCP", function)
+}
@@ -0,0 +1,186 @@
+package modbusorm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// rtuCRC the CRC of the RTU frame, low byte first
+func rtuCRC(frame []byte) []byte {
+	crc := uint16(0xFFFF)
+	for _, b := range frame {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return []byte{byte(crc), byte(crc >> 8)}
+}
+
+// serveRTUOverTCP serve the device with RTU frames on a local TCP listener, like a serial-to-Ethernet converter
+/*
+	A request is read at once, the responses are written a byte at a time,
+	so the client must frame them by their length.
+	Requests with a bad CRC are not answered.
+*/
+func serveRTUOverTCP(t *testing.T, d *testDevice) (host string, port int) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, rtuMaxSize)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					frame := buf[:n]
+					if n < 4 || !bytes.Equal(rtuCRC(frame[:n-2]), frame[n-2:]) {
+						continue
+					}
+					resp := append([]byte{frame[0]}, d.handle(frame[1:n-2])...)
+					resp = append(resp, rtuCRC(resp)...)
+					for i := range resp {
+						if _, err := conn.Write(resp[i : i+1]); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func TestRTUResponseLength(t *testing.T) {
+	readRequest := []byte{1, modbus.FuncCodeReadHoldingRegisters, 0, 0, 0, 2, 0, 0}
+	diagRequest := []byte{1, funcCodeDiagnostics, 0, 0, 0xA5, 0x37, 0, 0}
+	tests := []struct {
+		name    string
+		head    []byte
+		request []byte
+		want    int
+		wantErr bool
+	}{
+		{"read", []byte{1, modbus.FuncCodeReadHoldingRegisters, 4}, readRequest, 9, false},
+		{"exception", []byte{1, modbus.FuncCodeReadHoldingRegisters | 0x80, 2}, readRequest, rtuExceptionSize, false},
+		{"write single", []byte{1, modbus.FuncCodeWriteSingleRegister, 0}, []byte{1, modbus.FuncCodeWriteSingleRegister}, 8, false},
+		{"write multiple", []byte{1, modbus.FuncCodeWriteMultipleRegisters, 0}, []byte{1, modbus.FuncCodeWriteMultipleRegisters}, 8, false},
+		{"mask write", []byte{1, modbus.FuncCodeMaskWriteRegister, 0}, []byte{1, modbus.FuncCodeMaskWriteRegister}, 10, false},
+		{"FIFO takes a 2 bytes byte count", []byte{1, modbus.FuncCodeReadFIFOQueue, 0}, []byte{1, modbus.FuncCodeReadFIFOQueue}, 0, false},
+		{"diagnostics echoes the request", []byte{1, funcCodeDiagnostics, 0}, diagRequest, len(diagRequest), false},
+		{"function mismatch", []byte{1, modbus.FuncCodeWriteSingleRegister, 0}, readRequest, 0, true},
+		{"unsupported function", []byte{1, 0x2B, 0}, []byte{1, 0x2B}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rtuResponseLength(tt.head, tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rtuResponseLength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rtuResponseLength() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRTUOverTCPClientHandler(t *testing.T) {
+	host, port := serveRTUOverTCP(t, newTestDevice(20))
+	handler := NewRTUOverTCPClientHandler(net.JoinHostPort(host, strconv.Itoa(port)))
+	handler.Timeout = time.Second
+	handler.SlaveId = 1
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.Close()
+	client := modbus.NewClient(handler)
+
+	// Each response must be framed exactly, or the next one would be corrupted on the same connection
+	results, err := client.ReadHoldingRegisters(10, 2)
+	if err != nil || !bytes.Equal(results, []byte{0, 10, 0, 11}) {
+		t.Fatalf("ReadHoldingRegisters() = %v, %v", results, err)
+	}
+
+	_, err = client.ReadHoldingRegisters(20, 1)
+	var mbErr *modbus.ModbusError
+	if !errors.As(err, &mbErr) || mbErr.ExceptionCode != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("ReadHoldingRegisters() of an illegal address error = %v, want an exception", err)
+	}
+
+	request, err := handler.Encode(&modbus.ProtocolDataUnit{FunctionCode: modbus.FuncCodeReadFIFOQueue, Data: []byte{0, 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := handler.Send(request)
+	if err != nil {
+		t.Fatalf("Send() of read FIFO queue error = %v", err)
+	}
+	// slave id, function code, byte count (2 bytes), FIFO count (2 bytes), 2 values and CRC
+	if want := 1 + 1 + 2 + 2 + 4 + 2; len(response) != want {
+		t.Fatalf("read FIFO queue response length = %d, want %d", len(response), want)
+	}
+	if pdu, err := handler.Decode(response); err != nil || !bytes.Equal(pdu.Data, []byte{0, 6, 0, 2, 0, 30, 0, 31}) {
+		t.Fatalf("Decode() of read FIFO queue = %v, %v", pdu, err)
+	}
+
+	echo, err := diagnostics(handler, 0, []byte{0xA5, 0x37})
+	if err != nil || !bytes.Equal(echo, []byte{0xA5, 0x37}) {
+		t.Fatalf("diagnostics() = %v, %v", echo, err)
+	}
+
+	if _, err = client.WriteSingleRegister(12, 0x1234); err != nil {
+		t.Fatalf("WriteSingleRegister() error = %v", err)
+	}
+	results, err = client.ReadHoldingRegisters(12, 1)
+	if err != nil || !bytes.Equal(results, []byte{0x12, 0x34}) {
+		t.Fatalf("ReadHoldingRegisters() after write = %v, %v", results, err)
+	}
+}
+
+func TestModbusRTUOverTCP(t *testing.T) {
+	host, port := serveRTUOverTCP(t, newTestDevice(20))
+	points := Point{
+		"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1},
+		"fault":   {Addr: 20, Quantity: 1},
+	}
+	m := NewModbusRTUOverTCP(host, port, points, WithTimeout(time.Second))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+
+	if err := m.SetValue(ctx, "voltage", uint16(2305)); err != nil {
+		t.Fatalf("SetValue() error = %v", err)
+	}
+	var voltage float64
+	if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 230.5 {
+		t.Fatalf("GetValue() = %v, %v, want 230.5", voltage, err)
+	}
+	var fault int
+	if _, ok := ExceptionCode(m.GetValue(ctx, "fault", &fault)); !ok {
+		t.Fatalf("GetValue() of an illegal address should report the exception")
+	}
+}
@@ -1,33 +1,190 @@
 package modbusorm
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/goburrow/modbus"
 )
 
+// ModbusRTUPool the pool of the serial bus
+/*
+	A serial bus allows only one transaction at a time,
+	Get checks out the bus exclusively and waits until it is free.
+	The devices on the bus take turns, and the calls of a device are served first in first out.
+*/
 type ModbusRTUPool struct {
-	client Client
+	mutex       sync.Mutex
+	client      Client
+	config      ModbusRTUPoolConfig
+	busy        bool
+	requests    waitQueue[error] // waiting Get calls, nil is sent when the bus is handed over
+	lastRelease time.Time
+	closed      bool
+
+	waitCount    int64
+	waitDuration time.Duration
+}
+
+type ModbusRTUPoolConfig struct {
+	// FrameDelay is the silent interval kept on the bus between two transactions
+	FrameDelay time.Duration
+	// Logger logs the bus lifecycle, nil logs nothing
+	Logger Logger
 }
 
-func NewModbusRTUPool(client Client) (ConnPool, error) {
+func NewModbusRTUPool(client Client, config ModbusRTUPoolConfig) (ConnPool, error) {
+	if config.Logger == nil {
+		config.Logger = nopLogger{}
+	}
+	config.Logger.Debug("modbus bus opened", "frame_delay", config.FrameDelay)
 	return &ModbusRTUPool{
 		client: client,
+		config: config,
 	}, nil
 }
 
-func (p *ModbusRTUPool) Get() (Client, error) {
+// Get check out the bus, wait until it is free or ctx is done
+func (p *ModbusRTUPool) Get(ctx context.Context) (Client, error) {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, ErrPoolClosed
+	}
+	if err := ctx.Err(); err != nil {
+		p.mutex.Unlock()
+		return nil, err
+	}
+
+	if !p.busy {
+		p.busy = true
+		p.mutex.Unlock()
+		return p.silent(ctx)
+	}
+
+	// Wait for the bus to be handed over
+	req := make(chan error, 1)
+	device := deviceFromContext(ctx)
+	p.requests.push(device, req)
+	p.waitCount++
+	p.mutex.Unlock()
+
+	waitStart := time.Now()
+	select {
+	case <-ctx.Done():
+		p.mutex.Lock()
+		p.waitDuration += time.Since(waitStart)
+		p.requests.remove(device, req)
+		p.mutex.Unlock()
+
+		// The bus may be handed over meanwhile, give it back
+		select {
+		case err := <-req:
+			if err == nil {
+				p.Put(p.client)
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	case err := <-req:
+		p.mutex.Lock()
+		p.waitDuration += time.Since(waitStart)
+		p.mutex.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		return p.silent(ctx)
+	}
+}
+
+// silent keep the bus silent for the frame delay since the last transaction, then hand out the client
+func (p *ModbusRTUPool) silent(ctx context.Context) (Client, error) {
+	p.mutex.Lock()
+	wait := p.config.FrameDelay - time.Since(p.lastRelease)
+	p.mutex.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			p.Put(p.client)
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
 	return p.client, nil
 }
 
+// Put give back the bus, hand it over to the first waiting Get
 func (p *ModbusRTUPool) Put(conn Client) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.lastRelease = time.Now()
+	if req, ok := p.requests.pop(); ok {
+		req <- nil
+		return nil
+	}
+	p.busy = false
 	return nil
 }
 
 func (p *ModbusRTUPool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	p.closed = true
+	p.config.Logger.Info("modbus bus closed")
+
+	// Wake up the waiting Get
+	for _, req := range p.requests.drain() {
+		req <- ErrPoolClosed
+	}
 	return p.client.Close()
 }
 
+func (p *ModbusRTUPool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := PoolStats{
+		MaxOpenConnections: 1,
+		OpenConnections:    1,
+		WaitCount:          p.waitCount,
+		WaitDuration:       p.waitDuration,
+	}
+	if p.closed {
+		stats.OpenConnections = 0
+	} else if p.busy {
+		stats.InUse = 1
+	} else {
+		stats.Idle = 1
+	}
+	return stats
+}
+
+// silentInterval the silent interval between two frames, 3.5 character times
+/*
+	A character is 1 start bit, the data bits, the parity bit and the stop bits.
+	For baud rates greater than 19200, a fixed 1.75ms is used, as recommended by the Modbus specification.
+*/
+func silentInterval(baudRate, dataBits int, parity string, stopBits int) time.Duration {
+	if baudRate <= 0 || baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	bits := 1 + dataBits + stopBits
+	if parity != "N" {
+		bits++
+	}
+	return time.Duration(bits) * time.Second * 7 / time.Duration(baudRate*2)
+}
+
 type ModbusRTUClient struct {
 	Client     modbus.Client
 	Handler    *modbus.RTUClientHandler
@@ -50,6 +207,10 @@ func (c *ModbusRTUClient) CreateTime() time.Time {
 	return c.createTime
 }
 
+func (c *ModbusRTUClient) SetSlaveID(slaveID uint8) {
+	c.Handler.SlaveId = slaveID
+}
+
 func (c *ModbusRTUClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
 	return c.Client.ReadCoils(address, quantity)
 }
@@ -93,3 +254,8 @@ func (c *ModbusRTUClient) MaskWriteRegister(address, andMask, orMask uint16) (re
 func (c *ModbusRTUClient) ReadFIFOQueue(address uint16) (results []byte, err error) {
 	return c.Client.ReadFIFOQueue(address)
 }
+
+// Diagnostics send a diagnostics request (FC08)
+func (c *ModbusRTUClient) Diagnostics(subFunction uint16, data []byte) (results []byte, err error) {
+	return diagnostics(c.Handler, subFunction, data)
+}
@@ -0,0 +1,188 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRTUPool(t *testing.T, frameDelay time.Duration) *ModbusRTUPool {
+	conn, err := newTestDialer(t, newTestDevice()).dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := NewModbusRTUPool(conn, ModbusRTUPoolConfig{FrameDelay: frameDelay})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool.(*ModbusRTUPool)
+}
+
+func TestModbusRTUPoolExclusive(t *testing.T) {
+	pool := newTestRTUPool(t, 0)
+
+	var mutex sync.Mutex
+	inUse, maxInUse := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				conn, err := pool.Get(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				inUse++
+				maxInUse = max(maxInUse, inUse)
+				mutex.Unlock()
+				if _, err := conn.ReadHoldingRegisters(10, 1); err != nil {
+					t.Error(err)
+				}
+				mutex.Lock()
+				inUse--
+				mutex.Unlock()
+				pool.Put(conn)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInUse != 1 {
+		t.Fatalf("max in use = %d, want the bus checked out exclusively", maxInUse)
+	}
+	if stats := pool.Stats(); stats.InUse != 0 || stats.Idle != 1 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+// waitInTurn start Get calls one after another, each records its id once it has the bus
+func waitInTurn(t *testing.T, pool ConnPool, stats func() PoolStats, ids []int, device func(id int) uint8) (*[]int, *sync.WaitGroup) {
+	var mutex sync.Mutex
+	order := []int{}
+	var wg sync.WaitGroup
+	for n, id := range ids {
+		id := id
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.Get(withDevice(context.Background(), device(id)))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, id)
+			mutex.Unlock()
+			pool.Put(conn)
+		}()
+		waitFor(t, "the Get to wait", func() bool { return stats().WaitCount == int64(n+1) })
+	}
+	return &order, &wg
+}
+
+func TestModbusRTUPoolFIFO(t *testing.T) {
+	pool := newTestRTUPool(t, 0)
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order, wg := waitInTurn(t, pool, pool.Stats, []int{1, 2, 3}, func(int) uint8 { return 1 })
+	pool.Put(conn)
+	wg.Wait()
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(*order, want) {
+		t.Fatalf("order = %v, want %v", *order, want)
+	}
+}
+
+func TestModbusRTUPoolFrameDelay(t *testing.T) {
+	const frameDelay = 30 * time.Millisecond
+	pool := newTestRTUPool(t, frameDelay)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(conn)
+	released := time.Now()
+	if conn, err = pool.Get(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(released); elapsed < frameDelay {
+		t.Fatalf("Get() after %v, want the silent interval %v", elapsed, frameDelay)
+	}
+	pool.Put(conn)
+
+	// A canceled Get during the silent interval gives back the bus
+	ctx, cancel := context.WithTimeout(context.Background(), frameDelay/3)
+	defer cancel()
+	if _, err := pool.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() error = %v, want the deadline", err)
+	}
+	if stats := pool.Stats(); stats.InUse != 0 {
+		t.Fatalf("InUse = %d after the canceled Get, want 0", stats.InUse)
+	}
+}
+
+func TestModbusRTUPoolWait(t *testing.T) {
+	pool := newTestRTUPool(t, 0)
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() of a busy bus error = %v, want the deadline", err)
+	}
+
+	// Put hands over the bus to the waiting call
+	closed := make(chan error, 1)
+	go func() {
+		_, err := pool.Get(context.Background())
+		closed <- err
+	}()
+	waitFor(t, "the Get to wait", func() bool { return pool.Stats().WaitCount == 2 })
+	pool.Put(conn)
+	if err := <-closed; err != nil {
+		t.Fatalf("Get() after Put error = %v", err)
+	}
+	// Close wakes up the waiting calls
+	go func() {
+		_, err := pool.Get(context.Background())
+		closed <- err
+	}()
+	waitFor(t, "the Get to wait", func() bool { return pool.Stats().WaitCount == 3 })
+	pool.Close()
+	if err := <-closed; !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("Get() of a closed pool error = %v", err)
+	}
+}
+
+func TestSilentInterval(t *testing.T) {
+	tests := []struct {
+		baudRate, dataBits int
+		parity             string
+		stopBits           int
+		want               time.Duration
+	}{
+		// 10 bits per character
+		{9600, 8, "N", 1, 3645833 * time.Nanosecond},
+		// 11 bits per character
+		{19200, 8, "E", 1, 2005208 * time.Nanosecond},
+		{115200, 8, "N", 1, 1750 * time.Microsecond},
+		{0, 8, "N", 1, 1750 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		if got := silentInterval(tt.baudRate, tt.dataBits, tt.parity, tt.stopBits); got != tt.want {
+			t.Errorf("silentInterval(%d, %d, %s, %d) = %v, want %v", tt.baudRate, tt.dataBits, tt.parity, tt.stopBits, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package modbusorm
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Sample a value with the time it was read and its quality, recognized in the struct fields
+/*
+	type Data struct {
+		Power modbusorm.Sample[float64] `morm:"power"`
+	}
+	GetValues sets Value, Time and Quality good for the points read.
+	For the points failed, Err is the error, Value and Time are kept from the last good read,
+	Quality is uncertain, or bad if the point was never read or the value is older than WithStaleAfter.
+	SetValues writes Value.
+*/
+type Sample[T any] struct {
+	Value T
+	// Time is the time Value was read
+	Time    time.Time
+	Quality Quality
+	// Err is the error of the latest read, if failed
+	Err error
+}
+
+func (s *Sample[T]) setRead(t time.Time) {
+	s.Time = t
+	s.Quality = QualityGood
+	s.Err = nil
+}
+
+func (s *Sample[T]) setFailed(err error, staleAfter time.Duration) {
+	s.Quality = QualityBad
+	if !s.Time.IsZero() && (staleAfter <= 0 || time.Since(s.Time) < staleAfter) {
+		s.Quality = QualityUncertain
+	}
+	s.Err = err
+}
+
+func (s *Sample[T]) readAt() time.Time {
+	return s.Time
+}
+
+// sample the methods of *Sample[T] for the ORM
+type sample interface {
+	setRead(t time.Time)
+	setFailed(err error, staleAfter time.Duration)
+	readAt() time.Time
+}
+
+var sampleType = reflect.TypeOf((*sample)(nil)).Elem()
+
+// isSampleType check if t is a Sample type
+func isSampleType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PointerTo(t).Implements(sampleType)
+}
+
+// asSample get the sample of the field, and the field of its Value
+func asSample(value reflect.Value) (sample, reflect.Value, bool) {
+	if !isSampleType(value.Type()) {
+		return nil, reflect.Value{}, false
+	}
+	if !value.CanAddr() || !value.CanInterface() {
+		// Not settable, only the Value is used
+		return nil, value.Field(0), true
+	}
+	return value.Addr().Interface().(sample), value.Field(0), true
+}
+
+// failSamples mark the samples of v not read since the time failed with err
+/*
+	In partial results mode, the samples are marked by the points failed.
+*/
+func (m *Modbus) failSamples(v any, filterMap map[string]bool, since time.Time, err error) {
+	var valuesErr *ValuesError
+	if errors.As(err, &valuesErr) {
+		return
+	}
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
+		return
+	}
+	m.walkPoints(val.Elem(), filterMap, func(name string, value reflect.Value, s sample) {
+		if s != nil && s.readAt().Before(since) {
+			s.setFailed(err, m.staleAfter)
+		}
+	})
+}
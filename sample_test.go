@@ -0,0 +1,123 @@
+package modbusorm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// deviceFailure answer every request with a server device failure exception
+func deviceFailure(pdu []byte) []byte {
+	return exceptionPDU(pdu[0], modbus.ExceptionCodeServerDeviceFailure)
+}
+
+type sampleValues struct {
+	Power  Sample[float64] `morm:"power"`
+	Energy Sample[uint16]  `morm:"energy"`
+}
+
+func newSampleTestModbus(t *testing.T, d *testDevice, opts ...ModbusOption) *Modbus {
+	host, port := serveTCP(t, d)
+	points := Point{
+		"power":  {Addr: 10, Quantity: 1, Coefficient: 0.1},
+		"energy": {Addr: 11, Quantity: 1},
+	}
+	m := NewModbusTCP(host, port, points, append([]ModbusOption{WithTimeout(time.Second)}, opts...)...)
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestSampleQuality(t *testing.T) {
+	for _, block := range []bool{true, false} {
+		name := "single"
+		if block {
+			name = "block"
+		}
+		t.Run(name, func(t *testing.T) {
+			d := newTestDevice()
+			m := newSampleTestModbus(t, d, WithBlock(block))
+			ctx := context.Background()
+
+			var v sampleValues
+			begin := time.Now()
+			if err := m.GetValues(ctx, &v); err != nil {
+				t.Fatalf("GetValues() error = %v", err)
+			}
+			for _, s := range []struct {
+				name    string
+				quality Quality
+				readAt  time.Time
+				err     error
+			}{
+				{"power", v.Power.Quality, v.Power.Time, v.Power.Err},
+				{"energy", v.Energy.Quality, v.Energy.Time, v.Energy.Err},
+			} {
+				if s.quality != QualityGood || s.err != nil || s.readAt.Before(begin) || s.readAt.After(time.Now()) {
+					t.Fatalf("%s = %v at %v, %v, want good read now", s.name, s.quality, s.readAt, s.err)
+				}
+			}
+			if v.Power.Value != 1 || v.Energy.Value != 11 {
+				t.Fatalf("GetValues() = %v, %v, want 1 and 11", v.Power.Value, v.Energy.Value)
+			}
+
+			// The last value read is kept, uncertain
+			readAt := v.Power.Time
+			d.setFault(deviceFailure)
+			if err := m.GetValues(ctx, &v); err == nil {
+				t.Fatal("GetValues() of a failing device error = nil")
+			}
+			if v.Power.Quality != QualityUncertain || v.Power.Err == nil || v.Power.Value != 1 || !v.Power.Time.Equal(readAt) {
+				t.Fatalf("power after a failed read = %+v, want the last value uncertain", v.Power)
+			}
+
+			// A point never read has no value to keep
+			var fresh sampleValues
+			if err := m.GetValues(ctx, &fresh); err == nil {
+				t.Fatal("GetValues() of a failing device error = nil")
+			}
+			if fresh.Power.Quality != QualityBad || fresh.Power.Err == nil {
+				t.Fatalf("power never read = %+v, want bad", fresh.Power)
+			}
+		})
+	}
+}
+
+func TestSampleStaleAfter(t *testing.T) {
+	d := newTestDevice()
+	m := newSampleTestModbus(t, d, WithStaleAfter(time.Minute))
+	ctx := context.Background()
+
+	var v sampleValues
+	if err := m.GetValues(ctx, &v); err != nil {
+		t.Fatalf("GetValues() error = %v", err)
+	}
+	d.setFault(deviceFailure)
+	if err := m.GetValues(ctx, &v); err == nil || v.Power.Quality != QualityUncertain {
+		t.Fatalf("GetValues() = %v, %v, want uncertain", v.Power.Quality, err)
+	}
+	v.Power.Time = time.Now().Add(-time.Hour)
+	if err := m.GetValues(ctx, &v); err == nil || v.Power.Quality != QualityBad {
+		t.Fatalf("GetValues() of a stale value = %v, %v, want bad", v.Power.Quality, err)
+	}
+}
+
+func TestSamplePartialResults(t *testing.T) {
+	d := newTestDevice(11)
+	m := newSampleTestModbus(t, d, WithPartialResults(true))
+
+	var v sampleValues
+	if err := m.GetValues(context.Background(), &v); err == nil {
+		t.Fatal("GetValues() error = nil, want the energy failed")
+	}
+	if v.Power.Quality != QualityGood || v.Power.Value != 1 {
+		t.Fatalf("power = %+v, want good", v.Power)
+	}
+	if code, ok := ExceptionCode(v.Energy.Err); v.Energy.Quality != QualityBad || !ok || code != modbus.ExceptionCodeIllegalDataAddress {
+		t.Fatalf("energy = %+v, want bad with an illegal data address", v.Energy)
+	}
+}
@@ -0,0 +1,267 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// SubscribeOptions options of Subscribe
+type SubscribeOptions struct {
+	// Interval is the poll interval, default 1s
+	Interval time.Duration
+	// Filter is the points of the struct to watch, all if empty
+	Filter []string
+	// Deadband is the absolute change of a number to emit an event, default any change
+	Deadband float64
+	// DeadbandPercent is the change of a number to emit an event, in percent of the last value emitted, default any change
+	DeadbandPercent float64
+	// Heartbeat is the interval the values are emitted at even if unchanged, default disabled
+	Heartbeat time.Duration
+	// StaleAfter is the time the last value stays uncertain after the reads failed, before it turns bad, default 0
+	StaleAfter time.Duration
+	// Buffer is the size of the events channel, default 16
+	Buffer int
+}
+
+// Event a change of a point
+type Event struct {
+	Point string
+	// Value is the decoded value, the last value read if the read failed
+	Value any
+	// Previous is the value of the last event of the point, nil for the first event
+	Previous any
+	// Time is the time of the read
+	Time    time.Time
+	Quality Quality
+	// Heartbeat reports whether the event is a periodic emission of an unchanged value
+	Heartbeat bool
+	// Err is the error of the read, if failed
+	Err error
+}
+
+// Subscribe watch the points and emit an event for each change
+/*
+	target is the name of a point, or a struct pointer like GetValues.
+	The values of a point name are float64, or the state names for a point with an enumeration.
+	The points are polled at opts.Interval with a Poller, an event is emitted:
+	when a number changes by more than opts.Deadband and opts.DeadbandPercent,
+	when a string, a bit or another value changes,
+	when the quality changes, like a failed read turns a value uncertain then bad,
+	and for all the values every opts.Heartbeat.
+	The first read emits all the values. The channel is closed when ctx is done.
+*/
+func (m *Modbus) Subscribe(ctx context.Context, target any, opts SubscribeOptions) (<-chan Event, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 16
+	}
+	group := PollGroup{Interval: opts.Interval, Filter: opts.Filter}
+	switch t := target.(type) {
+	case string:
+		p, ok := m.points[t]
+		if !ok {
+			return nil, fmt.Errorf("point for %s not found", t)
+		}
+		valueType := reflect.TypeOf(float64(0))
+		if len(p.Enum) > 0 {
+			valueType = reflect.TypeOf("")
+		}
+		group.Name = t
+		group.Value = reflect.New(reflect.StructOf([]reflect.StructField{
+			{Name: "Value", Type: valueType, Tag: reflect.StructTag(fmt.Sprintf(`morm:"%s"`, t))},
+		})).Interface()
+		group.Filter = nil
+	default:
+		group.Value = target
+	}
+
+	sub := &subscription{
+		m:         m,
+		opts:      opts,
+		filterMap: parseFilter(group.Filter),
+		events:    make(chan Event, opts.Buffer),
+		points:    make(map[string]*watchedPoint),
+	}
+	group.OnSnapshot = func(s Snapshot) { sub.update(ctx, s) }
+	poller, err := NewPoller(m, PollerConfig{Groups: []PollGroup{group}})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		poller.Run(ctx)
+		close(sub.events)
+	}()
+	return sub.events, nil
+}
+
+// subscription the state of the points watched by Subscribe
+type subscription struct {
+	m             *Modbus
+	opts          SubscribeOptions
+	filterMap     map[string]bool
+	events        chan Event
+	points        map[string]*watchedPoint
+	lastHeartbeat time.Time
+}
+
+// watchedPoint the last event of a point
+type watchedPoint struct {
+	seen     bool // an event was emitted
+	value    any  // the value of the last event
+	hasValue bool // a value was read
+	quality  Quality
+	lastGood time.Time // the time of the last good read
+}
+
+// update compare the snapshot with the last events, and emit the changes
+func (s *subscription) update(ctx context.Context, snap Snapshot) {
+	var callErr error
+	pointErrs := make(map[string]error)
+	if snap.Err != nil {
+		var valuesErr *ValuesError
+		if errors.As(snap.Err, &valuesErr) {
+			for _, e := range valuesErr.Errors {
+				pointErrs[e.Point] = e.Err
+			}
+		} else {
+			callErr = snap.Err
+		}
+	}
+	heartbeat := false
+	if s.lastHeartbeat.IsZero() {
+		s.lastHeartbeat = snap.Time
+	} else if s.opts.Heartbeat > 0 && snap.Time.Sub(s.lastHeartbeat) >= s.opts.Heartbeat {
+		heartbeat = true
+		s.lastHeartbeat = snap.Time
+	}
+
+	s.m.walkPoints(reflect.ValueOf(snap.Value).Elem(), s.filterMap, func(name string, value reflect.Value, _ sample) {
+		w, ok := s.points[name]
+		if !ok {
+			w = &watchedPoint{}
+			s.points[name] = w
+		}
+		err := callErr
+		if err == nil {
+			err = pointErrs[name]
+		}
+
+		if err != nil {
+			quality := QualityBad
+			if w.hasValue && snap.Time.Sub(w.lastGood) < s.opts.StaleAfter {
+				quality = QualityUncertain
+			}
+			changed := !w.seen || quality != w.quality
+			if changed || heartbeat {
+				s.emit(ctx, Event{Point: name, Value: w.value, Previous: w.value, Time: snap.Time, Quality: quality, Heartbeat: !changed, Err: err})
+			}
+			w.seen, w.quality = true, quality
+			return
+		}
+
+		v := derefValue(value)
+		changed := !w.hasValue || w.quality != QualityGood || s.changed(w.value, v)
+		if changed || heartbeat {
+			s.emit(ctx, Event{Point: name, Value: v, Previous: w.value, Time: snap.Time, Quality: QualityGood, Heartbeat: !changed})
+		}
+		if changed {
+			// The deadband is relative to the value emitted, slow drifts are emitted too
+			w.value = v
+		}
+		w.seen, w.hasValue, w.quality, w.lastGood = true, true, QualityGood, snap.Time
+	})
+}
+
+// changed check if the value changed more than the deadbands
+func (s *subscription) changed(from, to any) bool {
+	a, okA := toFloat64(from)
+	b, okB := toFloat64(to)
+	if !okA || !okB {
+		return !reflect.DeepEqual(from, to)
+	}
+	diff := math.Abs(b - a)
+	if diff == 0 {
+		return false
+	}
+	if s.opts.Deadband > 0 && diff <= s.opts.Deadband {
+		return false
+	}
+	if s.opts.DeadbandPercent > 0 && diff <= math.Abs(a)*s.opts.DeadbandPercent/100 {
+		return false
+	}
+	return true
+}
+
+func (s *subscription) emit(ctx context.Context, e Event) {
+	select {
+	case s.events <- e:
+	case <-ctx.Done():
+	}
+}
+
+// walkPoints call fn with the fields of the points in v, nested structs included
+/*
+	For the Sample fields, value is the field of the Value, and s is the sample.
+*/
+func (m *Modbus) walkPoints(v reflect.Value, filterMap map[string]bool, fn func(name string, value reflect.Value, s sample)) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i)
+		if !value.CanInterface() {
+			continue
+		}
+		s, sampleValue, isSample := asSample(value)
+		if value.Kind() == reflect.Struct && !isSample {
+			m.walkPoints(value, filterMap, fn)
+			continue
+		}
+		exist, name := getPointTag(t.Field(i))
+		if !exist {
+			continue
+		}
+		if len(filterMap) != 0 && !filterMap[name] {
+			continue
+		}
+		if p, ok := m.points[name]; !ok || p.Forbidden {
+			continue
+		}
+		if isSample {
+			value = sampleValue
+		}
+		fn(name, value, s)
+	}
+}
+
+// derefValue the value of the field, the value pointed to for pointers, nil for nil pointers
+func derefValue(v reflect.Value) any {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	return v.Interface()
+}
+
+// toFloat64 convert a number to float64
+func toFloat64(v any) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
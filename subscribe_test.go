@@ -0,0 +1,46 @@
+package modbusorm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubscribePointName(t *testing.T) {
+	host, port := serveTCP(t, newTestDevice())
+	points := Point{
+		"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1},
+		"state":   {Addr: 11, Quantity: 1, Enum: map[int]string{0: "STOPPED", 11: "RUNNING"}},
+	}
+	m := NewModbusTCP(host, port, points, WithTimeout(time.Second))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tests := []struct {
+		point string
+		want  any
+	}{
+		{"voltage", float64(1)},
+		{"state", "RUNNING"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.point, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			events, err := m.Subscribe(ctx, tt.point, SubscribeOptions{Interval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case e := <-events:
+				if e.Err != nil || e.Point != tt.point || e.Value != tt.want {
+					t.Fatalf("first event = %+v, want %v", e, tt.want)
+				}
+			case <-ctx.Done():
+				t.Fatal("no event")
+			}
+		})
+	}
+}
@@ -1,8 +1,8 @@
 package modbusorm
 
 import (
-	"net"
-	"strings"
+	"context"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -10,22 +10,67 @@ import (
 )
 
 type ModbusTCPPool struct {
-	mutex       sync.Mutex
-	connections chan Client
-	factory     func() (Client, error)
-	closed      bool
-	config      ModbusTCPPoolConfig
+	mutex    sync.Mutex
+	idle     []idleConn
+	numOpen  int                    // number of opened connections, and connections being opened
+	requests waitQueue[connRequest] // waiting Get calls
+	factory  func() (Client, error)
+	closed   bool
+	config   ModbusTCPPoolConfig
+
+	waitCount         int64
+	waitDuration      time.Duration
+	maxLifetimeClosed int64
+	maxIdleClosed     int64
+	maxIdleTimeClosed int64
+
+	state        ConnState
+	reconnecting bool
+	watchers     map[chan ConnState]struct{}
+	done         chan struct{} // closed when the pool is closed
+}
+
+// idleConn a connection kept in the pool and the time it was put back
+type idleConn struct {
+	conn  Client
+	since time.Time
+}
+
+// connRequest a connection handed over to a waiting Get
+/*
+	If conn and err are both nil, the slot of a closed connection is handed over,
+	the waiting Get should open a new connection.
+*/
+type connRequest struct {
+	conn Client
+	err  error
 }
 
 type ModbusTCPPoolConfig struct {
 	MaxOpenConns    int
 	ConnMaxLifetime time.Duration
+	// MaxIdleConns is the max number of idle connections, default MaxOpenConns
+	MaxIdleConns int
+	// ConnMaxIdleTime is the max time a connection may be idle, 0 means no limit
+	ConnMaxIdleTime time.Duration
+	// Lazy starts the pool with no connection instead of dialing MaxOpenConns connections
+	Lazy bool
+	// ReconnectMinBackoff and ReconnectMaxBackoff bound the exponential backoff of the background reconnect
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
+	// HealthCheckInterval is how often the idle connections are probed, 0 disables the check
+	HealthCheckInterval time.Duration
+	// HealthCheckProbe is the probe request, nil disables the check
+	HealthCheckProbe Probe
+	// Logger logs the connection lifecycle, nil logs nothing
+	Logger Logger
 }
 
 type ModbusTCPClient struct {
 	Client     modbus.Client
 	Handler    *modbus.TCPClientHandler
 	createTime time.Time
+	broken     bool
 }
 
 func (c *ModbusTCPClient) Connect() error {
@@ -36,66 +81,97 @@ func (c *ModbusTCPClient) Close() error {
 	return c.Handler.Close()
 }
 
+// IsAlive report false once a request failed with a transport error
 func (c *ModbusTCPClient) IsAlive() bool {
-	_, err := c.Client.ReadHoldingRegisters(1, 1)
-	if err != nil {
-		if strings.Contains(err.Error(), "EOF") {
-			return false
-		} else if strings.Contains(err.Error(), "connection refused") {
-			return false
-		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			return false
-		}
+	return !c.broken
+}
+
+// track mark the connection broken if err is a transport error
+func (c *ModbusTCPClient) track(err error) {
+	if isTransportError(err) {
+		c.broken = true
 	}
-	return true
 }
 
 func (c *ModbusTCPClient) CreateTime() time.Time {
 	return c.createTime
 }
 
+func (c *ModbusTCPClient) SetSlaveID(slaveID uint8) {
+	c.Handler.SlaveId = slaveID
+}
+
 func (c *ModbusTCPClient) ReadCoils(address, quantity uint16) (results []byte, err error) {
-	return c.Client.ReadCoils(address, quantity)
+	results, err = c.Client.ReadCoils(address, quantity)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) ReadDiscreteInputs(address, quantity uint16) (results []byte, err error) {
-	return c.Client.ReadDiscreteInputs(address, quantity)
+	results, err = c.Client.ReadDiscreteInputs(address, quantity)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) WriteSingleCoil(address, value uint16) (results []byte, err error) {
-	return c.Client.WriteSingleCoil(address, value)
+	results, err = c.Client.WriteSingleCoil(address, value)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) WriteMultipleCoils(address, quantity uint16, value []byte) (results []byte, err error) {
-	return c.Client.WriteMultipleCoils(address, quantity, value)
+	results, err = c.Client.WriteMultipleCoils(address, quantity, value)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) ReadInputRegisters(address, quantity uint16) (results []byte, err error) {
-	return c.Client.ReadInputRegisters(address, quantity)
+	results, err = c.Client.ReadInputRegisters(address, quantity)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) ReadHoldingRegisters(address, quantity uint16) (results []byte, err error) {
-	return c.Client.ReadHoldingRegisters(address, quantity)
+	results, err = c.Client.ReadHoldingRegisters(address, quantity)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) WriteSingleRegister(address, value uint16) (results []byte, err error) {
-	return c.Client.WriteSingleRegister(address, value)
+	results, err = c.Client.WriteSingleRegister(address, value)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) WriteMultipleRegisters(address, quantity uint16, value []byte) (results []byte, err error) {
-	return c.Client.WriteMultipleRegisters(address, quantity, value)
+	results, err = c.Client.WriteMultipleRegisters(address, quantity, value)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity uint16, value []byte) (results []byte, err error) {
-	return c.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	results, err = c.Client.ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress, writeQuantity, value)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) MaskWriteRegister(address, andMask, orMask uint16) (results []byte, err error) {
-	return c.Client.MaskWriteRegister(address, andMask, orMask)
+	results, err = c.Client.MaskWriteRegister(address, andMask, orMask)
+	c.track(err)
+	return
 }
 
 func (c *ModbusTCPClient) ReadFIFOQueue(address uint16) (results []byte, err error) {
-	return c.Client.ReadFIFOQueue(address)
+	results, err = c.Client.ReadFIFOQueue(address)
+	c.track(err)
+	return
+}
+
+// Diagnostics send a diagnostics request (FC08)
+func (c *ModbusTCPClient) Diagnostics(subFunction uint16, data []byte) (results []byte, err error) {
+	results, err = diagnostics(c.Handler, subFunction, data)
+	c.track(err)
+	return
 }
 
 func NewModbusTCPPool(config ModbusTCPPoolConfig, factory func() (Client, error)) (ConnPool, error) {
@@ -105,64 +181,458 @@ func NewModbusTCPPool(config ModbusTCPPoolConfig, factory func() (Client, error)
 	if config.MaxOpenConns <= 0 {
 		config.MaxOpenConns = 5
 	}
+	if config.MaxIdleConns <= 0 || config.MaxIdleConns > config.MaxOpenConns {
+		config.MaxIdleConns = config.MaxOpenConns
+	}
+	if config.ReconnectMinBackoff <= 0 {
+		config.ReconnectMinBackoff = 500 * time.Millisecond
+	}
+	if config.ReconnectMaxBackoff < config.ReconnectMinBackoff {
+		config.ReconnectMaxBackoff = max(30*time.Second, config.ReconnectMinBackoff)
+	}
+	if config.Logger == nil {
+		config.Logger = nopLogger{}
+	}
 
 	pool := &ModbusTCPPool{
-		factory:     factory,
-		connections: make(chan Client, config.MaxOpenConns),
-		config:      config,
+		factory:  factory,
+		idle:     make([]idleConn, 0, config.MaxOpenConns),
+		config:   config,
+		state:    ConnStateConnecting,
+		watchers: make(map[chan ConnState]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if config.Lazy {
+		// Dial on demand, the state is connecting until the first dial
+		pool.startHealthCheck()
+		pool.startCleaner()
+		return pool, nil
 	}
 
-	for i := 0; i < config.MaxOpenConns; i++ {
+	for i := 0; i < config.MaxIdleConns; i++ {
 		conn, err := factory()
 		if err != nil {
+			config.Logger.Warn("modbus dial failed", "error", err)
+			pool.Close()
 			return nil, err
 		}
-		pool.connections <- conn
+		pool.idle = append(pool.idle, idleConn{conn: conn, since: time.Now()})
+		pool.numOpen++
 	}
+	pool.state = ConnStateUp
+	pool.startHealthCheck()
+	pool.startCleaner()
 
 	return pool, nil
 }
 
 // Get get a connection from pool
-func (p *ModbusTCPPool) Get() (Client, error) {
+/*
+	If no connection is idle and MaxOpenConns connections are open,
+	Get waits until one is put back or ctx is done.
+*/
+func (p *ModbusTCPPool) Get(ctx context.Context) (Client, error) {
+	p.mutex.Lock()
 	if p.closed {
+		p.mutex.Unlock()
 		return nil, ErrPoolClosed
 	}
+	if err := ctx.Err(); err != nil {
+		p.mutex.Unlock()
+		return nil, err
+	}
+
+	// Reuse the most recently used idle connection, the stale ones are closed and their slots freed
+	var stale []Client
+	defer func() {
+		for _, conn := range stale {
+			conn.Close()
+		}
+	}()
+	for n := len(p.idle); n > 0; n = len(p.idle) {
+		ic := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		if reason := p.staleReason(ic, time.Now()); reason != "" {
+			p.config.Logger.Debug("modbus connection closed", "reason", reason)
+			stale = append(stale, ic.conn)
+			p.numOpen--
+			continue
+		}
+		p.mutex.Unlock()
+		return ic.conn, nil
+	}
+
+	// Open a new connection under the limit
+	if p.numOpen < p.config.MaxOpenConns {
+		p.numOpen++
+		p.mutex.Unlock()
+		return p.open()
+	}
 
+	// Wait for a connection to be put back
+	req := make(chan connRequest, 1)
+	device := deviceFromContext(ctx)
+	p.requests.push(device, req)
+	p.waitCount++
+	p.mutex.Unlock()
+
+	waitStart := time.Now()
 	select {
-	case conn := <-p.connections:
-		return conn, nil
-	default:
-		// if no avaliable connction, new one
-		return p.factory()
+	case <-ctx.Done():
+		p.mutex.Lock()
+		p.waitDuration += time.Since(waitStart)
+		p.requests.remove(device, req)
+		p.mutex.Unlock()
+
+		// The connection may be handed over meanwhile, give it back
+		select {
+		case r := <-req:
+			if r.conn != nil {
+				p.Put(r.conn)
+			} else if r.err == nil {
+				p.release()
+			}
+		default:
+		}
+		return nil, ctx.Err()
+	case r := <-req:
+		p.mutex.Lock()
+		p.waitDuration += time.Since(waitStart)
+		p.mutex.Unlock()
+
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.conn != nil {
+			return r.conn, nil
+		}
+		return p.open()
 	}
 }
 
-// Put put the connection to the pool
-func (p *ModbusTCPPool) Put(conn Client) error {
-	if p.closed {
-		return conn.Close()
+// open open a new connection in a slot already counted in numOpen
+/*
+	The device is dialed even while the connection is down, so a device back online is used
+	without waiting for the backoff of the background reconnect.
+*/
+func (p *ModbusTCPPool) open() (Client, error) {
+	conn, err := p.factory()
+	if err != nil {
+		p.config.Logger.Warn("modbus dial failed", "error", err)
+		p.release()
+		p.mutex.Lock()
+		p.connDown()
+		p.mutex.Unlock()
+		return nil, err
+	}
+	p.config.Logger.Debug("modbus connection opened")
+	p.mutex.Lock()
+	p.setState(ConnStateUp)
+	p.mutex.Unlock()
+	return conn, nil
+}
+
+// connDown mark the connection down and reconnect in the background, the caller must hold the mutex
+func (p *ModbusTCPPool) connDown() {
+	p.setState(ConnStateDown)
+	p.startReconnect(p.config.ReconnectMinBackoff)
+}
+
+// startReconnect start the background reconnect if not running, the caller must hold the mutex
+func (p *ModbusTCPPool) startReconnect(delay time.Duration) {
+	if p.reconnecting || p.closed {
+		return
+	}
+	p.reconnecting = true
+	go p.reconnect(delay)
+}
+
+// reconnect dial the device with exponential backoff and jitter until it is connected
+func (p *ModbusTCPPool) reconnect(delay time.Duration) {
+	backoff := p.config.ReconnectMinBackoff
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(delay):
+		}
+
+		p.mutex.Lock()
+		if p.state == ConnStateUp {
+			// Reconnected by a dial on demand
+			p.reconnecting = false
+			p.mutex.Unlock()
+			return
+		}
+		if p.numOpen >= p.config.MaxOpenConns {
+			// No slot to dial, wait for the connections in use to be put back or discarded
+			p.mutex.Unlock()
+			delay = withJitter(backoff)
+			continue
+		}
+		p.numOpen++
+		p.setState(ConnStateConnecting)
+		p.mutex.Unlock()
+
+		conn, err := p.factory()
+
+		p.mutex.Lock()
+		if err == nil {
+			p.reconnecting = false
+			p.setState(ConnStateUp)
+			p.mutex.Unlock()
+			p.config.Logger.Info("modbus reconnected")
+			p.putIdle(conn)
+			return
+		}
+		p.setState(ConnStateDown)
+		p.mutex.Unlock()
+		p.release()
+
+		delay = withJitter(backoff)
+		p.config.Logger.Warn("modbus reconnect failed", "backoff", delay, "error", err)
+		backoff = min(backoff*2, p.config.ReconnectMaxBackoff)
+	}
+}
+
+// withJitter randomize the backoff in [backoff/2, backoff)
+func withJitter(backoff time.Duration) time.Duration {
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// setState set the connection state and notify the watchers, the caller must hold the mutex
+func (p *ModbusTCPPool) setState(state ConnState) {
+	if p.state == state {
+		return
+	}
+	p.config.Logger.Info("modbus connection state changed", "from", p.state.String(), "to", state.String())
+	p.state = state
+	for w := range p.watchers {
+		// Keep only the latest state for slow watchers
+		select {
+		case <-w:
+		default:
+		}
+		w <- state
+	}
+}
+
+// State get the connection state
+func (p *ModbusTCPPool) State() ConnState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.state
+}
+
+// WatchState watch the connection state, the current state is sent first
+/*
+	Slow watchers only receive the latest state. The channel is closed when ctx is done.
+*/
+func (p *ModbusTCPPool) WatchState(ctx context.Context) <-chan ConnState {
+	w := make(chan ConnState, 1)
+
+	p.mutex.Lock()
+	w <- p.state
+	p.watchers[w] = struct{}{}
+	p.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		p.mutex.Lock()
+		delete(p.watchers, w)
+		close(w)
+		p.mutex.Unlock()
+	}()
+	return w
+}
+
+// release free the slot of a closed connection, or hand it over to the first waiting Get
+func (p *ModbusTCPPool) release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.closed {
+		if req, ok := p.requests.pop(); ok {
+			req <- connRequest{}
+			return
+		}
 	}
+	p.numOpen--
+}
 
-	if time.Since(conn.CreateTime()) > p.config.ConnMaxLifetime {
+// Put put the connection to the pool
+func (p *ModbusTCPPool) Put(conn Client) error {
+	if p.isExpired(conn, time.Now()) {
 		// if connection is expired, close it
-		return conn.Close()
+		p.mutex.Lock()
+		p.maxLifetimeClosed++
+		p.mutex.Unlock()
+		p.config.Logger.Debug("modbus connection closed", "reason", "max lifetime")
+		return p.discard(conn)
 	}
 	if !conn.IsAlive() {
-		// if connection is not alive, close it
-		return conn.Close()
+		// if a request on the connection failed with a transport error, close it
+		p.config.Logger.Info("modbus connection closed", "reason", "broken")
+		return p.discard(conn)
 	}
 
+	return p.putIdle(conn)
+}
+
+// putIdle hand over the connection to the first waiting Get, or keep it idle
+func (p *ModbusTCPPool) putIdle(conn Client) error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	select {
-	case p.connections <- conn:
+	if p.closed {
+		p.numOpen--
+		return conn.Close()
+	}
+	// Hand over to the first waiting Get
+	if req, ok := p.requests.pop(); ok {
+		req <- connRequest{conn: conn}
 		return nil
-	default:
-		// if the pool is full, close the connection
+	}
+	if len(p.idle) >= p.config.MaxIdleConns {
+		// if there are enough idle connections, close it
+		p.config.Logger.Debug("modbus connection closed", "reason", "max idle conns")
+		p.maxIdleClosed++
+		p.numOpen--
 		return conn.Close()
 	}
+	p.idle = append(p.idle, idleConn{conn: conn, since: time.Now()})
+	return nil
+}
+
+// isExpired check if the connection is older than ConnMaxLifetime
+func (p *ModbusTCPPool) isExpired(conn Client, now time.Time) bool {
+	return p.config.ConnMaxLifetime > 0 && now.Sub(conn.CreateTime()) > p.config.ConnMaxLifetime
+}
+
+// staleReason check why the idle connection should be closed, and count it, the caller must hold the mutex
+/*
+	An empty reason means the connection can be kept.
+*/
+func (p *ModbusTCPPool) staleReason(ic idleConn, now time.Time) string {
+	if p.isExpired(ic.conn, now) {
+		p.maxLifetimeClosed++
+		return "max lifetime"
+	}
+	if p.config.ConnMaxIdleTime > 0 && now.Sub(ic.since) > p.config.ConnMaxIdleTime {
+		p.maxIdleTimeClosed++
+		return "max idle time"
+	}
+	if !ic.conn.IsAlive() {
+		return "broken"
+	}
+	return ""
+}
+
+// startCleaner start closing the stale idle connections in the background
+func (p *ModbusTCPPool) startCleaner() {
+	interval := p.config.ConnMaxIdleTime
+	if interval <= 0 || (p.config.ConnMaxLifetime > 0 && p.config.ConnMaxLifetime < interval) {
+		interval = p.config.ConnMaxLifetime
+	}
+	if interval <= 0 {
+		return
+	}
+	// Check twice in each period, but not too often
+	interval = max(interval/2, minCleanerInterval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.cleanStale()
+			}
+		}
+	}()
+}
+
+const minCleanerInterval = time.Second
+
+// cleanStale close the stale idle connections
+func (p *ModbusTCPPool) cleanStale() {
+	p.mutex.Lock()
+	var stale []Client
+	now := time.Now()
+	kept := p.idle[:0]
+	for _, ic := range p.idle {
+		if reason := p.staleReason(ic, now); reason != "" {
+			p.config.Logger.Debug("modbus connection closed", "reason", reason)
+			stale = append(stale, ic.conn)
+			continue
+		}
+		kept = append(kept, ic)
+	}
+	p.idle = kept
+	p.numOpen -= len(stale)
+	p.mutex.Unlock()
+
+	for _, conn := range stale {
+		conn.Close()
+	}
+}
+
+// discard close the connection and free its slot
+func (p *ModbusTCPPool) discard(conn Client) error {
+	err := conn.Close()
+	p.release()
+	return err
+}
+
+// startHealthCheck start probing the idle connections if enabled
+func (p *ModbusTCPPool) startHealthCheck() {
+	if p.config.HealthCheckInterval <= 0 || p.config.HealthCheckProbe == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.config.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.healthCheck()
+			}
+		}
+	}()
+}
+
+// healthCheck probe the connections idle for at least the interval, and close the bad ones
+func (p *ModbusTCPPool) healthCheck() {
+	p.mutex.Lock()
+	var checking []Client
+	kept := p.idle[:0]
+	for _, ic := range p.idle {
+		if time.Since(ic.since) >= p.config.HealthCheckInterval {
+			checking = append(checking, ic.conn)
+			continue
+		}
+		kept = append(kept, ic)
+	}
+	p.idle = kept
+	p.mutex.Unlock()
+
+	for _, conn := range checking {
+		if err := p.config.HealthCheckProbe(conn); err != nil {
+			p.config.Logger.Warn("modbus health check failed", "error", err)
+			p.discard(conn)
+			continue
+		}
+		p.putIdle(conn)
+	}
 }
 
 // Close close the pool
@@ -175,17 +645,36 @@ func (p *ModbusTCPPool) Close() error {
 	}
 
 	p.closed = true
+	close(p.done)
+	p.config.Logger.Info("modbus pool closed")
 
-	for {
-		select {
-		case conn, ok := <-p.connections:
-			if !ok {
-				return nil
-			}
-			conn.Close()
-		default:
-			close(p.connections)
-			return nil
-		}
+	for _, ic := range p.idle {
+		ic.conn.Close()
+	}
+	p.numOpen -= len(p.idle)
+	p.idle = nil
+
+	// Wake up the waiting Get
+	for _, req := range p.requests.drain() {
+		req <- connRequest{err: ErrPoolClosed}
+	}
+	return nil
+}
+
+// Stats get the statistics of the pool
+func (p *ModbusTCPPool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return PoolStats{
+		MaxOpenConnections: p.config.MaxOpenConns,
+		OpenConnections:    p.numOpen,
+		InUse:              p.numOpen - len(p.idle),
+		Idle:               len(p.idle),
+		WaitCount:          p.waitCount,
+		WaitDuration:       p.waitDuration,
+		MaxIdleClosed:      p.maxIdleClosed,
+		MaxIdleTimeClosed:  p.maxIdleTimeClosed,
+		MaxLifetimeClosed:  p.maxLifetimeClosed,
 	}
 }
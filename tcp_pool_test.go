@@ -0,0 +1,412 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+var errOffline = errors.New("device offline")
+
+// testDialer dial the device served by serveTCP, and fail while the device is offline
+type testDialer struct {
+	addr    string
+	mutex   sync.Mutex
+	dials   int
+	offline bool
+}
+
+func newTestDialer(t *testing.T, d *testDevice) *testDialer {
+	host, port := serveTCP(t, d)
+	return &testDialer{addr: net.JoinHostPort(host, strconv.Itoa(port))}
+}
+
+func (d *testDialer) dial() (Client, error) {
+	d.mutex.Lock()
+	d.dials++
+	offline := d.offline
+	d.mutex.Unlock()
+	if offline {
+		return nil, errOffline
+	}
+	handler := modbus.NewTCPClientHandler(d.addr)
+	handler.Timeout = time.Second
+	if err := handler.Connect(); err != nil {
+		return nil, err
+	}
+	return &ModbusTCPClient{Client: modbus.NewClient(handler), Handler: handler, createTime: time.Now()}, nil
+}
+
+func (d *testDialer) setOffline(offline bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.offline = offline
+}
+
+func (d *testDialer) dialCount() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.dials
+}
+
+func newTestTCPPool(t *testing.T, config ModbusTCPPoolConfig, dialer *testDialer) *ModbusTCPPool {
+	pool, err := NewModbusTCPPool(config, dialer.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool.(*ModbusTCPPool)
+}
+
+func TestModbusTCPPoolLazy(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 2, Lazy: true}, dialer)
+
+	// Nothing is dialed until a connection is needed
+	time.Sleep(50 * time.Millisecond)
+	if n := dialer.dialCount(); n != 0 {
+		t.Fatalf("dials before Get = %d, want 0", n)
+	}
+	if stats := pool.Stats(); stats.OpenConnections != 0 {
+		t.Fatalf("OpenConnections before Get = %d, want 0", stats.OpenConnections)
+	}
+	if state := pool.State(); state != ConnStateConnecting {
+		t.Fatalf("State() before Get = %v, want connecting", state)
+	}
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if _, err := conn.ReadHoldingRegisters(10, 1); err != nil {
+		t.Fatalf("ReadHoldingRegisters() error = %v", err)
+	}
+	pool.Put(conn)
+	if n, state := dialer.dialCount(), pool.State(); n != 1 || state != ConnStateUp {
+		t.Fatalf("after Get, dials = %d and State() = %v, want 1 and up", n, state)
+	}
+}
+
+func TestModbusTCPPoolDialWhileDown(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	dialer.setOffline(true)
+	// The background reconnect waits far longer than the test
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 2, Lazy: true, ReconnectMinBackoff: time.Minute}, dialer)
+	ctx := context.Background()
+
+	if _, err := pool.Get(ctx); !errors.Is(err, errOffline) {
+		t.Fatalf("Get() of an offline device error = %v", err)
+	}
+	if state := pool.State(); state != ConnStateDown {
+		t.Fatalf("State() = %v, want down", state)
+	}
+
+	// The device is back, Get dials it instead of waiting for the backoff
+	dialer.setOffline(false)
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get() after the device is back error = %v", err)
+	}
+	pool.Put(conn)
+	if state := pool.State(); state != ConnStateUp {
+		t.Fatalf("State() = %v, want up", state)
+	}
+	if stats := pool.Stats(); stats.OpenConnections != 1 {
+		t.Fatalf("OpenConnections = %d, want 1, the failed dials free their slots", stats.OpenConnections)
+	}
+}
+
+func TestModbusTCPPoolReconnect(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	dialer.setOffline(true)
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{
+		MaxOpenConns:        1,
+		Lazy:                true,
+		ReconnectMinBackoff: 10 * time.Millisecond,
+		ReconnectMaxBackoff: 20 * time.Millisecond,
+	}, dialer)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	states := pool.WatchState(ctx)
+
+	if _, err := pool.Get(ctx); err == nil {
+		t.Fatal("Get() of an offline device error = nil")
+	}
+	dialer.setOffline(false)
+	// The background reconnect brings the connection up without any Get
+	for state := range states {
+		if state == ConnStateUp {
+			break
+		}
+	}
+	if state := pool.State(); state != ConnStateUp {
+		t.Fatalf("State() = %v, want up", state)
+	}
+	waitFor(t, "the connection reconnected idle", func() bool { return pool.Stats().Idle == 1 })
+}
+
+func TestModbusTCPPoolMaxOpenConns(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 2}, dialer)
+	ctx := context.Background()
+
+	c1, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The third Get waits for a connection to be put back
+	got := make(chan Client, 1)
+	go func() {
+		conn, err := pool.Get(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- conn
+	}()
+	waitFor(t, "the Get to wait", func() bool { return pool.Stats().WaitCount == 1 })
+	select {
+	case <-got:
+		t.Fatal("Get() returned over MaxOpenConns")
+	case <-time.After(20 * time.Millisecond):
+	}
+	pool.Put(c1)
+	if conn := <-got; conn != c1 {
+		t.Fatalf("Get() = %p, want the connection put back %p", conn, c1)
+	}
+	pool.Put(c1)
+	pool.Put(c2)
+	if stats := pool.Stats(); stats.OpenConnections != 2 || stats.InUse != 0 || dialer.dialCount() != 2 {
+		t.Fatalf("Stats() = %+v with %d dials, want 2 open connections", stats, dialer.dialCount())
+	}
+}
+
+func TestModbusTCPPoolConcurrentLimit(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 2, Lazy: true}, dialer)
+
+	var mutex sync.Mutex
+	inUse, maxInUse := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				conn, err := pool.Get(context.Background())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mutex.Lock()
+				inUse++
+				maxInUse = max(maxInUse, inUse)
+				mutex.Unlock()
+				if _, err := conn.ReadHoldingRegisters(10, 1); err != nil {
+					t.Error(err)
+				}
+				mutex.Lock()
+				inUse--
+				mutex.Unlock()
+				pool.Put(conn)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInUse > 2 || dialer.dialCount() > 2 {
+		t.Fatalf("max in use = %d with %d dials, want at most 2", maxInUse, dialer.dialCount())
+	}
+	if stats := pool.Stats(); stats.InUse != 0 || stats.OpenConnections > 2 {
+		t.Fatalf("Stats() = %+v", stats)
+	}
+}
+
+func TestModbusTCPPoolWaitCanceled(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 1}, dialer)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get() error = %v, want the deadline", err)
+	}
+	pool.Put(conn)
+	if again, err := pool.Get(context.Background()); err != nil || again != conn {
+		t.Fatalf("Get() after the canceled wait = %p, %v, want %p", again, err, conn)
+	}
+}
+
+func TestModbusTCPPoolCancelDuringHandOver(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 1}, dialer)
+
+	// The slot of a discarded connection is handed over while the waiting Get is canceled,
+	// either the Get takes the slot or the slot is freed, it is never lost
+	for i := 0; i < 50; i++ {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		got := make(chan Client, 1)
+		go func() {
+			conn, _ := pool.Get(ctx)
+			got <- conn
+		}()
+		waitFor(t, "the Get to wait", func() bool { return pool.Stats().WaitCount == int64(i+1) })
+		conn.(*ModbusTCPClient).broken = true
+		go pool.Put(conn)
+		cancel()
+		if conn := <-got; conn != nil {
+			pool.Put(conn)
+		}
+		waitFor(t, "the slot to be freed", func() bool { return pool.Stats().InUse == 0 })
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatalf("Get() after the canceled hand overs error = %v", err)
+	}
+	pool.Put(conn)
+	if stats := pool.Stats(); stats.OpenConnections != 1 {
+		t.Fatalf("OpenConnections = %d, want 1", stats.OpenConnections)
+	}
+}
+
+func TestModbusTCPPoolMaxIdleConns(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 3, MaxIdleConns: 1}, dialer)
+
+	var conns []Client
+	for i := 0; i < 3; i++ {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	if stats := pool.Stats(); stats.Idle != 1 || stats.OpenConnections != 1 || stats.MaxIdleClosed != 2 {
+		t.Fatalf("Stats() = %+v, want 1 idle and 2 closed by MaxIdleConns", stats)
+	}
+}
+
+func TestModbusTCPPoolStaleOnGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ModbusTCPPoolConfig
+		closed func(PoolStats) int64
+	}{
+		{"max lifetime", ModbusTCPPoolConfig{MaxOpenConns: 1, ConnMaxLifetime: 30 * time.Millisecond}, func(s PoolStats) int64 { return s.MaxLifetimeClosed }},
+		{"max idle time", ModbusTCPPoolConfig{MaxOpenConns: 1, ConnMaxIdleTime: 30 * time.Millisecond}, func(s PoolStats) int64 { return s.MaxIdleTimeClosed }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := newTestDialer(t, newTestDevice())
+			pool := newTestTCPPool(t, tt.config, dialer)
+
+			conn, err := pool.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool.Put(conn)
+			time.Sleep(40 * time.Millisecond)
+
+			// The stale idle connection is closed and replaced, before the cleaner runs
+			fresh, err := pool.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Put(fresh)
+			if fresh == conn || dialer.dialCount() != 2 || tt.closed(pool.Stats()) != 1 {
+				t.Fatalf("Get() of a stale connection = %p after %p, %d dials, Stats() = %+v", fresh, conn, dialer.dialCount(), pool.Stats())
+			}
+			if _, err := fresh.ReadHoldingRegisters(10, 1); err != nil {
+				t.Fatalf("ReadHoldingRegisters() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestModbusTCPPoolCleaner(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 2, ConnMaxIdleTime: 50 * time.Millisecond}, dialer)
+	if stats := pool.Stats(); stats.Idle != 2 {
+		t.Fatalf("Idle = %d, want 2", stats.Idle)
+	}
+
+	// The idle connections are closed in the background, at most every minCleanerInterval
+	waitFor(t, "the idle connections closed", func() bool {
+		stats := pool.Stats()
+		return stats.Idle == 0 && stats.OpenConnections == 0 && stats.MaxIdleTimeClosed == 2
+	})
+}
+
+func TestModbusTCPPoolPutBroken(t *testing.T) {
+	dialer := newTestDialer(t, newTestDevice())
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 1}, dialer)
+
+	conn, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like after a transport error
+	conn.(*ModbusTCPClient).broken = true
+	pool.Put(conn)
+	if stats := pool.Stats(); stats.OpenConnections != 0 || stats.Idle != 0 {
+		t.Fatalf("Stats() after Put of a broken connection = %+v, want it closed", stats)
+	}
+
+	// The next Get dials a fresh connection
+	fresh, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(fresh)
+	if fresh == conn || dialer.dialCount() != 2 {
+		t.Fatalf("Get() = %p after %p, %d dials, want a fresh connection", fresh, conn, dialer.dialCount())
+	}
+}
+
+func TestModbusTCPPoolHealthCheck(t *testing.T) {
+	d := newTestDevice()
+	dialer := newTestDialer(t, d)
+	var failing int32
+	probe := func(conn Client) error {
+		if atomic.LoadInt32(&failing) == 1 {
+			return errOffline
+		}
+		return ProbeRegister(10)(conn)
+	}
+	pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 1, HealthCheckInterval: 10 * time.Millisecond, HealthCheckProbe: probe}, dialer)
+
+	// The good idle connection is probed and kept
+	waitFor(t, "the probes", func() bool { return d.requestCount(modbus.FuncCodeReadHoldingRegisters) >= 2 })
+	if stats := pool.Stats(); stats.OpenConnections != 1 || dialer.dialCount() != 1 {
+		t.Fatalf("Stats() = %+v after %d dials, want the connection kept", stats, dialer.dialCount())
+	}
+
+	// The bad one is closed
+	atomic.StoreInt32(&failing, 1)
+	waitFor(t, "the bad connection closed", func() bool { return pool.Stats().OpenConnections == 0 })
+}
@@ -0,0 +1,208 @@
+package modbusorm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// oidModbusRole the extension of the Modbus/TCP Security role in X.509 certificates
+var oidModbusRole = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// ModbusRole get the Modbus/TCP Security role of the certificate, return false if it has no role
+func ModbusRole(cert *x509.Certificate) (string, bool, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidModbusRole) {
+			continue
+		}
+		var role string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &role, "utf8"); err != nil {
+			return "", false, fmt.Errorf("invalid modbus role extension: %w", err)
+		}
+		return role, true, nil
+	}
+	return "", false, nil
+}
+
+// loadTLSConfig build the TLS config of the client from the PEM files
+/*
+	certFile and keyFile are the client certificate for the mutual authentication,
+	caFile is the CA certificates to verify the server, the system pool is used if it is empty.
+*/
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("load CA certificates failed: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificate found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// certificateRole get the role of the first client certificate in the config
+func certificateRole(config *tls.Config) (string, error) {
+	if len(config.Certificates) == 0 || len(config.Certificates[0].Certificate) == 0 {
+		return "", nil
+	}
+	cert := config.Certificates[0].Leaf
+	if cert == nil {
+		var err error
+		if cert, err = x509.ParseCertificate(config.Certificates[0].Certificate[0]); err != nil {
+			return "", fmt.Errorf("parse client certificate failed: %w", err)
+		}
+	}
+	role, _, err := ModbusRole(cert)
+	return role, err
+}
+
+// TLSClientHandler send MBAP frames over TLS, for Modbus/TCP Security
+/*
+	The MBAP framing of modbus.TCPClientHandler is used, Address is the host:port of the device, usually port 802.
+	TLS 1.2 is the min version, whatever the config says.
+*/
+type TLSClientHandler struct {
+	modbus.TCPClientHandler
+	TLSConfig *tls.Config
+	// HandshakeTimeout is the max time of the TLS handshake, default Timeout
+	HandshakeTimeout time.Duration
+
+	mutex sync.Mutex
+	conn  *tls.Conn
+}
+
+// NewTLSClientHandler allocates a TLSClientHandler
+func NewTLSClientHandler(address string, config *tls.Config) *TLSClientHandler {
+	handler := &TLSClientHandler{TLSConfig: config}
+	handler.Address = address
+	handler.Timeout = 10 * time.Second
+	return handler
+}
+
+// Connect dial the device and complete the TLS handshake
+func (h *TLSClientHandler) Connect() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.connect()
+}
+
+func (h *TLSClientHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	config := h.TLSConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(h.Address); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	dialer := net.Dialer{Timeout: h.Timeout}
+	raw, err := dialer.Dial("tcp", h.Address)
+	if err != nil {
+		return err
+	}
+	handshakeTimeout := h.HandshakeTimeout
+	if handshakeTimeout == 0 {
+		handshakeTimeout = h.Timeout
+	}
+	conn := tls.Client(raw, config)
+	if handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+	if err = conn.Handshake(); err != nil {
+		raw.Close()
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	h.conn = conn
+	return nil
+}
+
+// Close close the connection
+func (h *TLSClientHandler) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.close()
+}
+
+func (h *TLSClientHandler) close() error {
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send send the request and read the response, the connection is closed on error
+func (h *TLSClientHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err = h.connect(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			h.close()
+		}
+	}()
+
+	var deadline time.Time
+	if h.Timeout > 0 {
+		deadline = time.Now().Add(h.Timeout)
+	}
+	if err = h.conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	if _, err = h.conn.Write(aduRequest); err != nil {
+		return
+	}
+	header := make([]byte, mbapHeaderSize)
+	if _, err = io.ReadFull(h.conn, header); err != nil {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length == 0 || length+mbapHeaderSize-1 > mbapMaxSize {
+		return nil, fmt.Errorf("modbus: length in response header '%v' is out of range", length)
+	}
+	aduResponse = make([]byte, length+mbapHeaderSize-1)
+	copy(aduResponse, header)
+	if _, err = io.ReadFull(h.conn, aduResponse[mbapHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return aduResponse, nil
+}
+
+// mbapMaxSize the max MBAP frame size
+const mbapMaxSize = 260
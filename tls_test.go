@@ -0,0 +1,274 @@
+package modbusorm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// testPKI the certificates of a local Modbus/TCP Security test
+type testPKI struct {
+	ca     *x509.Certificate
+	server tls.Certificate
+	// client carries the role "operator"
+	client tls.Certificate
+}
+
+// issueCert issue a certificate of the template, self-signed if parent is nil
+func issueCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// roleExtension the Modbus/TCP Security role extension of the certificates
+func roleExtension(t *testing.T, role string) pkix.Extension {
+	value, err := asn1.MarshalWithParams(role, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidModbusRole, Value: value}
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	now := time.Now()
+	ca, caKey, _ := issueCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, _, server := issueCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "device"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, client := issueCert(t, &x509.Certificate{
+		SerialNumber:    big.NewInt(3),
+		Subject:         pkix.Name{CommonName: "client"},
+		NotBefore:       now.Add(-time.Hour),
+		NotAfter:        now.Add(time.Hour),
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{roleExtension(t, "operator")},
+	}, ca, caKey)
+	return &testPKI{ca: ca, server: server, client: client}
+}
+
+func (p *testPKI) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(p.ca)
+	return pool
+}
+
+// serveTLS serve the device with MBAP frames over TLS on a local listener, the client certificates are required
+func serveTLS(t *testing.T, d *testDevice, pki *testPKI) (host string, port int) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{pki.server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pki.pool(),
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveMBAP(t, ln, d)
+}
+
+// writePEM write the blocks to a PEM file in dir
+func writePEM(t *testing.T, dir, name string, blocks ...*pem.Block) string {
+	path := filepath.Join(dir, name)
+	var data []byte
+	for _, b := range blocks {
+		data = append(data, pem.EncodeToMemory(b)...)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestModbusTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	host, port := serveTLS(t, newTestDevice(), pki)
+
+	// The client certificate, key and CA are loaded from PEM files, like in production
+	dir := t.TempDir()
+	key, err := x509.MarshalPKCS8PrivateKey(pki.client.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, dir, "client.pem", &pem.Block{Type: "CERTIFICATE", Bytes: pki.client.Certificate[0]})
+	keyFile := writePEM(t, dir, "client.key", &pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	caFile := writePEM(t, dir, "ca.pem", &pem.Block{Type: "CERTIFICATE", Bytes: pki.ca.Raw})
+
+	points := Point{"voltage": {Addr: 10, Quantity: 1, Coefficient: 0.1}}
+	m := NewModbusTCP(host, port, points, WithTLSFiles(certFile, keyFile, caFile), WithTimeout(time.Second))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if role := m.Role(); role != "operator" {
+		t.Errorf("Role() = %q, want operator", role)
+	}
+
+	ctx := context.Background()
+	if err := m.SetValue(ctx, "voltage", uint16(2305)); err != nil {
+		t.Fatalf("SetValue() error = %v", err)
+	}
+	var voltage float64
+	if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 230.5 {
+		t.Fatalf("GetValue() = %v, %v, want 230.5", voltage, err)
+	}
+}
+
+func TestTLSClientHandlerClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	host, port := serveTLS(t, newTestDevice(), pki)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		wantErr bool
+	}{
+		{"mutual", &tls.Config{RootCAs: pki.pool(), Certificates: []tls.Certificate{pki.client}}, false},
+		{"no client certificate", &tls.Config{RootCAs: pki.pool()}, true},
+		{"unknown server CA", &tls.Config{Certificates: []tls.Certificate{pki.client}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewTLSClientHandler(addr, tt.config)
+			handler.Timeout = time.Second
+			defer handler.Close()
+
+			// With TLS 1.3, the server rejects the client certificate after the client completed the handshake
+			err := handler.Connect()
+			if err == nil {
+				_, err = modbus.NewClient(handler).ReadHoldingRegisters(10, 1)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("request error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSClientHandlerHandshakeTimeout(t *testing.T) {
+	// The device accepts the connection but never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	handler := NewTLSClientHandler(ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	handler.Timeout = 10 * time.Second
+	handler.HandshakeTimeout = 100 * time.Millisecond
+	begin := time.Now()
+	err = handler.Connect()
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Connect() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Fatalf("Connect() took %v, want the handshake timeout", elapsed)
+	}
+}
+
+func TestModbusRole(t *testing.T) {
+	now := time.Now()
+	template := func(extensions ...pkix.Extension) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			Subject:         pkix.Name{CommonName: "client"},
+			NotBefore:       now.Add(-time.Hour),
+			NotAfter:        now.Add(time.Hour),
+			ExtraExtensions: extensions,
+		}
+	}
+	notUTF8, err := asn1.Marshal(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template *x509.Certificate
+		wantRole string
+		wantOK   bool
+		wantErr  bool
+	}{
+		{"role", template(roleExtension(t, "operator")), "operator", true, false},
+		{"no role", template(), "", false, false},
+		{"invalid role", template(pkix.Extension{Id: oidModbusRole, Value: notUTF8}), "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, _, tlsCert := issueCert(t, tt.template, nil, nil)
+			role, ok, err := ModbusRole(cert)
+			if (err != nil) != tt.wantErr || role != tt.wantRole || ok != tt.wantOK {
+				t.Fatalf("ModbusRole() = %q, %v, %v, want %q, %v, wantErr %v", role, ok, err, tt.wantRole, tt.wantOK, tt.wantErr)
+			}
+
+			// The leaf is parsed from the client certificate of the config
+			role, err = certificateRole(&tls.Config{Certificates: []tls.Certificate{tlsCert}})
+			if (err != nil) != tt.wantErr || role != tt.wantRole {
+				t.Fatalf("certificateRole() = %q, %v, want %q, wantErr %v", role, err, tt.wantRole, tt.wantErr)
+			}
+		})
+	}
+
+	if role, err := certificateRole(&tls.Config{}); err != nil || role != "" {
+		t.Fatalf("certificateRole() without client certificate = %q, %v", role, err)
+	}
+}
@@ -0,0 +1,69 @@
+package modbusorm
+
+import "context"
+
+// Tracer start the spans of the ORM operations, the modbusotel module adapts OpenTelemetry
+/*
+	The spans are:
+	modbus.GetValue, modbus.GetValues, modbus.SetValue and modbus.SetValues for the calls,
+	modbus.plan for the block planning, modbus.acquire for the wait for a pooled connection,
+	modbus.request for each attempt of a request sent to the device, and modbus.poll for the polls of a Poller.
+	The attributes are modbus.target, modbus.slave_id, modbus.mode, modbus.function_code,
+	modbus.address.start, modbus.address.end, modbus.quantity, modbus.attempt, modbus.points,
+	modbus.blocks, modbus.plan.cached and modbus.groups.
+*/
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span a span started by the Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// End end the span, err is recorded if not nil
+	End(err error)
+}
+
+// Attribute an attribute of a span, Value is a string, int, bool or []string
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// nopTracer the default tracer, traces nothing
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs ...Attribute) {}
+func (nopSpan) End(err error)                    {}
+
+// startSpan start a span with the target and the slave id of m
+func (m *Modbus) startSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if _, ok := m.tracer.(nopTracer); ok {
+		return ctx, nopSpan{}
+	}
+	attrs = append([]Attribute{
+		{Key: "modbus.target", Value: m.target()},
+		{Key: "modbus.slave_id", Value: int(m.slaveID)},
+	}, attrs...)
+	return m.tracer.Start(ctx, name, attrs...)
+}
+
+// requestAttributes the span attributes of the request
+func requestAttributes(req Request) []Attribute {
+	attrs := []Attribute{
+		{Key: "modbus.function_code", Value: int(req.FunctionCode)},
+		{Key: "modbus.address.start", Value: int(req.Address)},
+		{Key: "modbus.address.end", Value: int(req.Address) + int(req.Quantity) - 1},
+		{Key: "modbus.quantity", Value: int(req.Quantity)},
+		{Key: "modbus.attempt", Value: req.Attempt},
+	}
+	if len(req.Points) > 0 {
+		attrs = append(attrs, Attribute{Key: "modbus.points", Value: req.Points})
+	}
+	return attrs
+}
@@ -0,0 +1,154 @@
+package modbusorm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/goburrow/modbus"
+)
+
+// recordSpan a span started by the recordTracer
+type recordSpan struct {
+	tracer *recordTracer
+	name   string
+	parent string
+	attrs  map[string]any
+	ended  bool
+	err    error
+}
+
+func (s *recordSpan) SetAttributes(attrs ...Attribute) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordSpan) End(err error) {
+	s.tracer.mutex.Lock()
+	defer s.tracer.mutex.Unlock()
+
+	s.ended, s.err = true, err
+}
+
+type spanKey struct{}
+
+// recordTracer record the spans started, the parent is the span in the context
+type recordTracer struct {
+	mutex sync.Mutex
+	spans []*recordSpan
+}
+
+func (r *recordTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recordSpan{tracer: r, name: name, attrs: make(map[string]any)}
+	if parent, ok := ctx.Value(spanKey{}).(*recordSpan); ok {
+		s.parent = parent.name
+	}
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.spans = append(r.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// find get the first span started with the name
+func (r *recordTracer) find(name string) *recordSpan {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, s := range r.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracingRequest(t *testing.T) {
+	for _, tt := range outcomes {
+		t.Run(tt.name, func(t *testing.T) {
+			tracer := &recordTracer{}
+			m := newObservedModbus(t, tt.fault, WithTracer(tracer))
+			var v uint16
+			err := m.GetValue(context.Background(), "power", &v)
+
+			call, acquire, request := tracer.find("modbus.GetValue"), tracer.find("modbus.acquire"), tracer.find("modbus.request")
+			if call == nil || acquire == nil || request == nil {
+				t.Fatalf("spans = %+v, want the call, the acquire and the request", tracer.spans)
+			}
+			if call.parent != "" || acquire.parent != call.name || request.parent != call.name {
+				t.Fatalf("parents = %q, %q, %q, want the acquire and the request in the call", call.parent, acquire.parent, request.parent)
+			}
+			want := map[string]any{
+				"modbus.target":        m.target(),
+				"modbus.slave_id":      1,
+				"modbus.function_code": int(modbus.FuncCodeReadHoldingRegisters),
+				"modbus.address.start": 10,
+				"modbus.address.end":   10,
+				"modbus.quantity":      1,
+				"modbus.attempt":       1,
+				"modbus.points":        []string{"power"},
+			}
+			if !reflect.DeepEqual(request.attrs, want) {
+				t.Fatalf("request attributes = %v, want %v", request.attrs, want)
+			}
+
+			// The spans are ended with the error, the request with the error of the device
+			for _, s := range []*recordSpan{call, acquire, request} {
+				if !s.ended {
+					t.Fatalf("span %s not ended", s.name)
+				}
+			}
+			if acquire.err != nil || call.err != err || (request.err == nil) != (err == nil) || !errors.Is(err, request.err) {
+				t.Fatalf("span errors = %v, %v, %v, want the cause of %v", call.err, acquire.err, request.err, err)
+			}
+			if code, ok := ExceptionCode(request.err); tt.name == "exception" && (!ok || code != modbus.ExceptionCodeServerDeviceFailure) {
+				t.Fatalf("request error = %v, want the exception", request.err)
+			}
+			if tt.name == "transport error" && !isTransportError(request.err) {
+				t.Fatalf("request error = %v, want a transport error", request.err)
+			}
+		})
+	}
+}
+
+func TestTracingBlocks(t *testing.T) {
+	tracer := &recordTracer{}
+	m := newObservedModbus(t, nil, WithTracer(tracer), WithBlock(true))
+	var v struct {
+		Power uint16 `morm:"power"`
+	}
+	for i := 0; i < 2; i++ {
+		if err := m.GetValues(context.Background(), &v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var plans []*recordSpan
+	for _, s := range tracer.spans {
+		if s.name == "modbus.plan" {
+			plans = append(plans, s)
+		}
+	}
+	if len(plans) != 2 {
+		t.Fatalf("plan spans = %d, want 2", len(plans))
+	}
+	// The plan of the struct type is cached after the first call
+	for i, s := range plans {
+		want := map[string]any{"modbus.target": m.target(), "modbus.slave_id": 1, "modbus.plan.cached": i > 0, "modbus.blocks": 1}
+		if s.parent != "modbus.GetValues" || !reflect.DeepEqual(s.attrs, want) {
+			t.Fatalf("plan %d in %q = %v, want %v", i, s.parent, s.attrs, want)
+		}
+	}
+	if call := tracer.find("modbus.GetValues"); call.attrs["modbus.mode"] != "block" {
+		t.Fatalf("GetValues attributes = %v, want the block mode", call.attrs)
+	}
+}
@@ -0,0 +1,132 @@
+package modbusorm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+const (
+	mbapHeaderSize = 7
+	// udpReadSize is larger than the max MBAP frame (260 bytes), so oversized datagrams are detected
+	udpReadSize = 512
+)
+
+// UDPClientHandler send MBAP frames over UDP
+/*
+	The MBAP framing of modbus.TCPClientHandler is used, Address is the host:port of the device.
+	A request is sent again if no response arrives in Timeout / (Retransmits + 1),
+	datagrams not matching the transaction id of the request, like late or duplicate responses, are discarded.
+	A retransmitted write may be executed twice by the device.
+*/
+type UDPClientHandler struct {
+	modbus.TCPClientHandler
+	// Retransmits is the number of times a request is sent again on timeout
+	Retransmits int
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// NewUDPClientHandler allocates a UDPClientHandler
+func NewUDPClientHandler(address string) *UDPClientHandler {
+	handler := &UDPClientHandler{Retransmits: 2}
+	handler.Address = address
+	handler.Timeout = 10 * time.Second
+	return handler
+}
+
+// Connect open the UDP socket, no packet is sent
+func (h *UDPClientHandler) Connect() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.connect()
+}
+
+func (h *UDPClientHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := net.Dial("udp", h.Address)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+// Close close the UDP socket
+func (h *UDPClientHandler) Close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send send the request and wait for the response with the same transaction id, retransmit on timeout
+func (h *UDPClientHandler) Send(aduRequest []byte) (aduResponse []byte, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err = h.connect(); err != nil {
+		return
+	}
+	attempts := h.Retransmits + 1
+	if attempts < 1 {
+		attempts = 1
+	}
+	attemptTimeout := h.Timeout / time.Duration(attempts)
+
+	buf := make([]byte, udpReadSize)
+	for i := 0; i < attempts; i++ {
+		if _, err = h.conn.Write(aduRequest); err != nil {
+			return
+		}
+		var deadline time.Time
+		if attemptTimeout > 0 {
+			deadline = time.Now().Add(attemptTimeout)
+		}
+		aduResponse, err = h.receive(aduRequest, deadline, buf)
+		if err == nil {
+			return
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return
+		}
+	}
+	return nil, fmt.Errorf("modbus: no response after %d attempts: %w", attempts, err)
+}
+
+// receive read datagrams until the response of the request arrives or the deadline
+func (h *UDPClientHandler) receive(aduRequest []byte, deadline time.Time, buf []byte) ([]byte, error) {
+	if err := h.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for {
+		n, err := h.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		adu := buf[:n]
+		if n <= mbapHeaderSize ||
+			binary.BigEndian.Uint16(adu) != binary.BigEndian.Uint16(aduRequest) ||
+			binary.BigEndian.Uint16(adu[2:]) != binary.BigEndian.Uint16(aduRequest[2:]) ||
+			int(binary.BigEndian.Uint16(adu[4:]))+mbapHeaderSize-1 != n {
+			// response of an earlier request, duplicate or malformed
+			continue
+		}
+		return append([]byte(nil), adu...), nil
+	}
+}
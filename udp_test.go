@@ -0,0 +1,103 @@
+package modbusorm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// serveUDP serve the device on a local UDP socket, respond gets the datagrams to send back for the nth request, from 1
+func serveUDP(t *testing.T, d *testDevice, respond func(n int, request, response []byte) [][]byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, udpReadSize)
+		for n := 1; ; n++ {
+			size, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			request := append([]byte(nil), buf[:size]...)
+			response := mbapResponse(request, d.handle(request[mbapHeaderSize:]))
+			for _, datagram := range respond(n, request, response) {
+				conn.WriteTo(datagram, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func newUDPTestClient(t *testing.T, addr string, retransmits int) modbus.Client {
+	handler := NewUDPClientHandler(addr)
+	handler.Timeout = 600 * time.Millisecond
+	handler.Retransmits = retransmits
+	if err := handler.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { handler.Close() })
+	return modbus.NewClient(handler)
+}
+
+func TestUDPClientHandlerRetransmit(t *testing.T) {
+	// The first datagram is lost
+	dropFirst := func(n int, request, response []byte) [][]byte {
+		if n == 1 {
+			return nil
+		}
+		return [][]byte{response}
+	}
+
+	t.Run("retransmitted", func(t *testing.T) {
+		client := newUDPTestClient(t, serveUDP(t, newTestDevice(), dropFirst), 2)
+		results, err := client.ReadHoldingRegisters(10, 1)
+		if err != nil || !bytes.Equal(results, []byte{0, 10}) {
+			t.Fatalf("ReadHoldingRegisters() = %v, %v", results, err)
+		}
+	})
+	t.Run("no retransmit", func(t *testing.T) {
+		client := newUDPTestClient(t, serveUDP(t, newTestDevice(), dropFirst), 0)
+		var netErr net.Error
+		if _, err := client.ReadHoldingRegisters(10, 1); !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("ReadHoldingRegisters() error = %v, want a timeout", err)
+		}
+	})
+}
+
+func TestUDPClientHandlerDuplicates(t *testing.T) {
+	twice := func(n int, request, response []byte) [][]byte {
+		return [][]byte{response, response}
+	}
+	client := newUDPTestClient(t, serveUDP(t, newTestDevice(), twice), 0)
+
+	// The duplicate of a response must not be taken as the response of the next request
+	for _, addr := range []uint16{10, 11, 12} {
+		results, err := client.ReadHoldingRegisters(addr, 1)
+		if err != nil || !bytes.Equal(results, []byte{0, byte(addr)}) {
+			t.Fatalf("ReadHoldingRegisters(%d) = %v, %v", addr, results, err)
+		}
+	}
+}
+
+func TestUDPClientHandlerStaleTransaction(t *testing.T) {
+	stale := func(n int, request, response []byte) [][]byte {
+		late := append([]byte(nil), response...)
+		binary.BigEndian.PutUint16(late, binary.BigEndian.Uint16(request)-1)
+		late[len(late)-1] = 0xFF
+		truncated := response[:mbapHeaderSize]
+		return [][]byte{late, truncated, response}
+	}
+	client := newUDPTestClient(t, serveUDP(t, newTestDevice(), stale), 0)
+
+	results, err := client.ReadHoldingRegisters(10, 1)
+	if err != nil || !bytes.Equal(results, []byte{0, 10}) {
+		t.Fatalf("ReadHoldingRegisters() = %v, %v, want the response of the transaction", results, err)
+	}
+}
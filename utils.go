@@ -2,8 +2,12 @@ package modbusorm
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+
+	"github.com/goburrow/modbus"
 )
 
 // parseDataToFloat64 transform data to float64
@@ -37,6 +41,48 @@ func binaryUint32(data []byte, order OrderType) uint32 {
 	return binary.BigEndian.Uint32(data)
 }
 
+// parseFloat64ToData encode the raw value in the registers of the data type, the reverse of parseDataToFloat64
+func parseFloat64ToData(raw float64, dataType PointDataType, order ...OrderType) ([]byte, error) {
+	binaryOrder := OrderTypeDefault
+	if len(order) > 0 {
+		binaryOrder = order[0]
+	}
+	var data []byte
+	switch dataType {
+	case PointDataTypeU16:
+		if raw < 0 || raw > math.MaxUint16 {
+			return nil, fmt.Errorf("value %v out of range of data type %d", raw, dataType)
+		}
+		data = make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(raw))
+	case PointDataTypeS16:
+		if raw < math.MinInt16 || raw > math.MaxInt16 {
+			return nil, fmt.Errorf("value %v out of range of data type %d", raw, dataType)
+		}
+		data = make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(int16(raw)))
+	case PointDataTypeU32:
+		if raw < 0 || raw > math.MaxUint32 {
+			return nil, fmt.Errorf("value %v out of range of data type %d", raw, dataType)
+		}
+		data = make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(raw))
+	case PointDataTypeS32:
+		if raw < math.MinInt32 || raw > math.MaxInt32 {
+			return nil, fmt.Errorf("value %v out of range of data type %d", raw, dataType)
+		}
+		data = make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(int32(raw)))
+	default:
+		return nil, fmt.Errorf("unsupported data type: %d", dataType)
+	}
+	if len(data) == 4 && binaryOrder == OrderTypeLittleEndian {
+		data[0], data[2] = data[2], data[0]
+		data[1], data[3] = data[3], data[1]
+	}
+	return data, nil
+}
+
 // getPointTag get morm tag
 func getPointTag(field reflect.StructField) (bool, string) {
 	name := field.Tag.Get("morm")
@@ -58,3 +104,64 @@ func byte2String(data []byte) string {
 	}
 	return string(data)
 }
+
+// isIllegalDataAddress check if err is an illegal data address exception from the device
+func isIllegalDataAddress(err error) bool {
+	var mbErr *modbus.ModbusError
+	return errors.As(err, &mbErr) && mbErr.ExceptionCode == modbus.ExceptionCodeIllegalDataAddress
+}
+
+// isTransportError check if err is a transport error, rather than an exception response from the device
+// or a rejection of an interceptor
+func isTransportError(err error) bool {
+	if err == nil || errors.Is(err, ErrRequestRejected) {
+		return false
+	}
+	var mbErr *modbus.ModbusError
+	return !errors.As(err, &mbErr)
+}
+
+// diagnostics send a diagnostics request (FC08) with the handler
+func diagnostics(handler modbus.ClientHandler, subFunction uint16, data []byte) ([]byte, error) {
+	request := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(request, subFunction)
+	copy(request[2:], data)
+	response, err := sendPDU(handler, &modbus.ProtocolDataUnit{FunctionCode: funcCodeDiagnostics, Data: request})
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Data) < 2 || binary.BigEndian.Uint16(response.Data) != subFunction {
+		return nil, fmt.Errorf("modbus: response sub-function does not match request '%v'", subFunction)
+	}
+	return response.Data[2:], nil
+}
+
+// funcCodeDiagnostics function code of diagnostics, not provided by goburrow/modbus
+const funcCodeDiagnostics = 8
+
+// sendPDU send a request with the handler and check the exception in the response
+func sendPDU(handler modbus.ClientHandler, request *modbus.ProtocolDataUnit) (*modbus.ProtocolDataUnit, error) {
+	aduRequest, err := handler.Encode(request)
+	if err != nil {
+		return nil, err
+	}
+	aduResponse, err := handler.Send(aduRequest)
+	if err != nil {
+		return nil, err
+	}
+	if err = handler.Verify(aduRequest, aduResponse); err != nil {
+		return nil, err
+	}
+	response, err := handler.Decode(aduResponse)
+	if err != nil {
+		return nil, err
+	}
+	if response.FunctionCode != request.FunctionCode {
+		mbErr := &modbus.ModbusError{FunctionCode: response.FunctionCode}
+		if len(response.Data) > 0 {
+			mbErr.ExceptionCode = response.Data[0]
+		}
+		return nil, mbErr
+	}
+	return response, nil
+}
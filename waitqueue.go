@@ -0,0 +1,98 @@
+package modbusorm
+
+import "context"
+
+// waitQueue waiting Get calls of the devices sharing a transport
+/*
+	The devices are served in turn, and the calls of a device first in first out,
+	so one device polling hard cannot starve the others.
+*/
+type waitQueue[T any] struct {
+	devices []uint8 // devices with waiting calls, in the order to be served
+	calls   map[uint8][]chan T
+}
+
+// push add a waiting call of the device
+func (q *waitQueue[T]) push(device uint8, req chan T) {
+	if q.calls == nil {
+		q.calls = make(map[uint8][]chan T)
+	}
+	if len(q.calls[device]) == 0 {
+		q.devices = append(q.devices, device)
+	}
+	q.calls[device] = append(q.calls[device], req)
+}
+
+// pop take the first waiting call of the next device
+func (q *waitQueue[T]) pop() (chan T, bool) {
+	if len(q.devices) == 0 {
+		return nil, false
+	}
+	device := q.devices[0]
+	q.devices = q.devices[1:]
+	calls := q.calls[device]
+	req := calls[0]
+	if len(calls) > 1 {
+		// The device waits for its turn again
+		q.calls[device] = calls[1:]
+		q.devices = append(q.devices, device)
+	} else {
+		delete(q.calls, device)
+	}
+	return req, true
+}
+
+// remove remove a waiting call, such as the one whose context is done
+func (q *waitQueue[T]) remove(device uint8, req chan T) {
+	calls := q.calls[device]
+	for i, r := range calls {
+		if r != req {
+			continue
+		}
+		calls = append(calls[:i], calls[i+1:]...)
+		if len(calls) > 0 {
+			q.calls[device] = calls
+			return
+		}
+		delete(q.calls, device)
+		for j, d := range q.devices {
+			if d == device {
+				q.devices = append(q.devices[:j], q.devices[j+1:]...)
+				break
+			}
+		}
+		return
+	}
+}
+
+// len the number of waiting calls
+func (q *waitQueue[T]) len() int {
+	n := 0
+	for _, calls := range q.calls {
+		n += len(calls)
+	}
+	return n
+}
+
+// drain take all the waiting calls
+func (q *waitQueue[T]) drain() []chan T {
+	var reqs []chan T
+	for req, ok := q.pop(); ok; req, ok = q.pop() {
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// deviceKey the context key of the device (slave id) a Get call is for
+type deviceKey struct{}
+
+// withDevice set the device a Get call is for
+func withDevice(ctx context.Context, slaveID uint8) context.Context {
+	return context.WithValue(ctx, deviceKey{}, slaveID)
+}
+
+// deviceFromContext get the device a Get call is for, 0 if not set
+func deviceFromContext(ctx context.Context) uint8 {
+	slaveID, _ := ctx.Value(deviceKey{}).(uint8)
+	return slaveID
+}
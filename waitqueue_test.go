@@ -0,0 +1,119 @@
+package modbusorm
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitQueue(t *testing.T) {
+	var q waitQueue[int]
+	reqs := make(map[chan int]string)
+	push := func(device uint8, name string) chan int {
+		req := make(chan int)
+		reqs[req] = name
+		q.push(device, req)
+		return req
+	}
+	push(1, "a1")
+	push(1, "a2")
+	b1 := push(2, "b1")
+	push(1, "a3")
+	push(3, "c1")
+	push(2, "b2")
+	q.remove(2, b1)
+
+	if n := q.len(); n != 5 {
+		t.Fatalf("len() = %d, want 5", n)
+	}
+	// The devices take turns, the calls of a device are first in first out
+	var order []string
+	for _, req := range q.drain() {
+		order = append(order, reqs[req])
+	}
+	if want := []string{"a1", "b2", "c1", "a2", "a3"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop() of an empty queue ok")
+	}
+}
+
+func TestPoolDeviceTurns(t *testing.T) {
+	// The device 1 polls hard, the device 2 waits only for the next turn
+	devices := []int{1, 1, 1, 2}
+	want := []int{0, 3, 1, 2}
+
+	t.Run("tcp", func(t *testing.T) {
+		pool := newTestTCPPool(t, ModbusTCPPoolConfig{MaxOpenConns: 1}, newTestDialer(t, newTestDevice()))
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		order, wg := waitInTurn(t, pool, pool.Stats, []int{0, 1, 2, 3}, func(id int) uint8 { return uint8(devices[id]) })
+		pool.Put(conn)
+		wg.Wait()
+		if !reflect.DeepEqual(*order, want) {
+			t.Fatalf("order = %v, want %v", *order, want)
+		}
+	})
+	t.Run("rtu", func(t *testing.T) {
+		pool := newTestRTUPool(t, 0)
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		order, wg := waitInTurn(t, pool, pool.Stats, []int{0, 1, 2, 3}, func(id int) uint8 { return uint8(devices[id]) })
+		pool.Put(conn)
+		wg.Wait()
+		if !reflect.DeepEqual(*order, want) {
+			t.Fatalf("order = %v, want %v", *order, want)
+		}
+	})
+}
+
+func TestModbusDevice(t *testing.T) {
+	d := newTestDevice()
+	host, port := serveTCP(t, d)
+	m := NewModbusTCP(host, port, Point{"voltage": {Addr: 10, Quantity: 1}}, WithTimeout(time.Second), WithMaxOpenConns(1))
+	if err := m.Conn(); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	meter := m.Device(2, Point{"energy": {Addr: 20, Quantity: 1}})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var voltage uint16
+			if err := m.GetValue(ctx, "voltage", &voltage); err != nil || voltage != 10 {
+				t.Errorf("GetValue() of the device 1 = %d, %v", voltage, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var energy uint16
+			if err := meter.GetValue(ctx, "energy", &energy); err != nil || energy != 20 {
+				t.Errorf("GetValue() of the device 2 = %d, %v", energy, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The devices share the connection, each request carries the slave id of its device
+	counts := map[byte]int{}
+	for _, unit := range d.unitIDs() {
+		counts[unit]++
+	}
+	if want := map[byte]int{1: 4, 2: 4}; !reflect.DeepEqual(counts, want) {
+		t.Fatalf("requests by slave id = %v, want %v", counts, want)
+	}
+	if n := d.connCount(); n != 1 {
+		t.Fatalf("connections = %d, want the transport shared", n)
+	}
+}